@@ -0,0 +1,264 @@
+package easyssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ScaleFT/sshkeys"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthFailure is an authentication source that could not be set up.
+type AuthFailure struct {
+	// Source names the setting the failure comes from, such as
+	// "KeyPath /home/drone/.ssh/id_rsa", "Key" or "ssh-agent".
+	Source string
+	Err    error
+}
+
+// AuthConfigError lists the authentication sources of a server that could not
+// be set up, such as a KeyPath that does not exist or a Key that does not
+// parse. It is returned before dialing with StrictAuth, and otherwise wrapped
+// in the error of a failed SSH handshake.
+type AuthConfigError struct {
+	Server   string
+	Failures []AuthFailure
+
+	// authenticating is set once the host key is accepted, when the
+	// handshake moves on to authentication.
+	authenticating bool
+}
+
+func (e *AuthConfigError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		failures = append(failures, failure.Source+": "+failure.Err.Error())
+	}
+	return fmt.Sprintf("easyssh: authentication setup for %s failed: %s", e.Server, strings.Join(failures, "; "))
+}
+
+// Unwrap returns the errors of every failure.
+func (e *AuthConfigError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+func (e *AuthConfigError) add(source string, err error) {
+	e.Failures = append(e.Failures, AuthFailure{Source: source, Err: err})
+}
+
+// wrap adds the failures, if any, to err when it comes from the
+// authentication phase of the handshake. Host key and key exchange failures
+// are left alone.
+func (e *AuthConfigError) wrap(err error) error {
+	var handshakeErr handshakeError
+	if e == nil || len(e.Failures) == 0 || !e.authenticating || !errors.As(err, &handshakeErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", err, e)
+}
+
+// hostKeyCallback returns callback recording in e that the host key was
+// accepted, so that wrap knows the handshake failed while authenticating.
+func (e *AuthConfigError) hostKeyCallback(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := callback(hostname, remote, key); err != nil {
+			return err
+		}
+		e.authenticating = true
+		return nil
+	}
+}
+
+// handshakeError marks errors of the SSH handshake, as opposed to dialing.
+type handshakeError struct {
+	error
+}
+
+func (e handshakeError) Unwrap() error {
+	return e.error
+}
+
+// ErrPublicKeyOnly is reported in an *AuthConfigError for a Key or KeyPath
+// holding a public key when it can not select an ssh-agent key, that is
+// without IdentitiesOnly or without an agent.
+var ErrPublicKeyOnly = errors.New("public key is only used with IdentitiesOnly and an ssh-agent")
+
+// PasswordKeyboardInteractive returns a keyboard-interactive challenge that
+// answers password prompts with password, for servers that only allow
+// keyboard-interactive logins. Any other prompt fails the authentication
+// attempt, so use a custom challenge for second factors.
+func PasswordKeyboardInteractive(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if !strings.Contains(strings.ToLower(question), "password") {
+				return nil, fmt.Errorf("easyssh: unexpected keyboard-interactive prompt %q", question)
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
+
+// agentSocket returns the ssh-agent socket to use for config, or "" when the
+// agent is disabled.
+func agentSocket(config DefaultConfig) string {
+	if config.DisableAgent {
+		return ""
+	}
+	if config.AgentSocket != "" {
+		return config.AgentSocket
+	}
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+// getPublicKeyFile reads a public key in authorized_keys format.
+func getPublicKeyFile(path string) (ssh.PublicKey, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	return key, err
+}
+
+// publicKeysCallback offers signers followed by the keys of agentClient, if
+// any. When only is not nil, agent keys other than those listed, or
+// certificates for them, are left out. Agent failures are added to authErr.
+func publicKeysCallback(signers []ssh.Signer, agentClient agent.Agent, only []ssh.PublicKey, authErr *AuthConfigError) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		if agentClient == nil {
+			return signers, nil
+		}
+
+		agentSigners, err := agentClient.Signers()
+		if err != nil {
+			authErr.add("ssh-agent", err)
+			return signers, nil
+		}
+
+		seen := map[string]bool{}
+		offered := make([]ssh.Signer, 0, len(signers)+len(agentSigners))
+		for _, signer := range signers {
+			seen[string(signer.PublicKey().Marshal())] = true
+			offered = append(offered, signer)
+		}
+		for _, signer := range agentSigners {
+			if seen[string(signer.PublicKey().Marshal())] {
+				continue
+			}
+			if only != nil && !matchesIdentity(signer.PublicKey(), only) {
+				continue
+			}
+			offered = append(offered, signer)
+		}
+		return offered, nil
+	}
+}
+
+// matchesIdentity reports whether key, or the key certified by it, is one of
+// identities.
+func matchesIdentity(key ssh.PublicKey, identities []ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if matchesIdentity(cert.Key, identities) {
+			return true
+		}
+	}
+	for _, identity := range identities {
+		if bytes.Equal(key.Marshal(), identity.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardAgent serves the agent channels opened by the server on client,
+// relaying them to the agent socket of config or, without one, to an
+// in-process agent holding the keys from Key and KeyPath. An SSH_AUTH_SOCK
+// that can not be reached is treated as no socket, an AgentSocket as an
+// error.
+func forwardAgent(client *ssh.Client, config DefaultConfig) error {
+	if sock := agentSocket(config); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			_ = conn.Close()
+			return agent.ForwardToRemote(client, sock)
+		}
+		if config.AgentSocket != "" {
+			return fmt.Errorf("easyssh: dial ssh-agent: %w", err)
+		}
+	}
+
+	keyring, err := newKeyring(config)
+	if err != nil {
+		return err
+	}
+	return agent.ForwardToAgent(client, keyring)
+}
+
+// newKeyring returns an in-process agent holding the private keys from Key
+// and KeyPath, along with the certificate for its key when one is
+// configured. Keys that can not be parsed are left out.
+func newKeyring(config DefaultConfig) (agent.Agent, error) {
+	if config.PassphraseSource != nil {
+		passphrase, err := config.PassphraseSource.Secret()
+		if err != nil {
+			return nil, err
+		}
+		config.Passphrase = passphrase
+	}
+
+	var keys [][]byte
+	if config.KeyPath != "" {
+		if buf, err := os.ReadFile(config.KeyPath); err == nil {
+			keys = append(keys, buf)
+		}
+	}
+	if config.Key != "" {
+		keys = append(keys, []byte(config.Key))
+	}
+
+	cert, err := getCertificate(config)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := agent.NewKeyring()
+	for _, buf := range keys {
+		var key interface{}
+		var err error
+		if config.Passphrase != "" {
+			key, err = sshkeys.ParseEncryptedRawPrivateKey(buf, []byte(config.Passphrase))
+		} else {
+			key, err = ssh.ParseRawPrivateKey(buf)
+		}
+		if err != nil {
+			continue
+		}
+
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			return nil, err
+		}
+
+		if cert == nil {
+			continue
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil || !bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal()) {
+			continue
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key, Certificate: cert}); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
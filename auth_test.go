@@ -0,0 +1,382 @@
+package easyssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ScaleFT/sshkeys"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPasswordKeyboardInteractive(t *testing.T) {
+	challenge := PasswordKeyboardInteractive("1234")
+
+	answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1234"}, answers)
+
+	answers, err = challenge("drone-scp", "", []string{"drone-scp@localhost's password:"}, []bool{false})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1234"}, answers)
+
+	// informational rounds carry no questions
+	answers, err = challenge("", "Welcome", nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, answers)
+
+	// second factors need a custom challenge
+	_, err = challenge("", "", []string{"Verification code: "}, []bool{true})
+	assert.Error(t, err)
+}
+
+// serveTestAgent serves keyring on a unix socket and returns its path.
+func serveTestAgent(t *testing.T, keyring agent.Agent) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return sock
+}
+
+func TestAgentSocket(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/env.sock")
+
+	assert.Equal(t, "/tmp/env.sock", agentSocket(DefaultConfig{}))
+	assert.Equal(t, "/tmp/agent.sock", agentSocket(DefaultConfig{AgentSocket: "/tmp/agent.sock"}))
+	assert.Equal(t, "", agentSocket(DefaultConfig{AgentSocket: "/tmp/agent.sock", DisableAgent: true}))
+}
+
+func TestStaleAgentSocket(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	t.Setenv("SSH_AUTH_SOCK", missing)
+
+	// a stale SSH_AUTH_SOCK is reported but the key file is still used
+	config, closer, authErr, err := getSSHConfig(DefaultConfig{
+		Server:     "localhost",
+		User:       "drone-scp",
+		KeyPath:    "./tests/.ssh/id_rsa",
+		StrictAuth: true,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, closer)
+	assert.Len(t, config.Auth, 1)
+	if assert.Len(t, authErr.Failures, 1) {
+		assert.Equal(t, "ssh-agent "+missing, authErr.Failures[0].Source)
+	}
+
+	// an explicit AgentSocket must be reachable
+	_, _, _, err = getSSHConfig(DefaultConfig{
+		Server:      "localhost",
+		User:        "drone-scp",
+		KeyPath:     "./tests/.ssh/id_rsa",
+		AgentSocket: missing,
+	})
+	assert.Error(t, err)
+}
+
+func TestPublicKeysCallback(t *testing.T) {
+	rsaSigner, err := getKeyFile("./tests/.ssh/id_rsa", "")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+
+	rsaKey, err := ssh.ParseRawPrivateKey(mustReadFile(t, "./tests/.ssh/id_rsa"))
+	if err != nil {
+		t.Fatalf("ParseRawPrivateKey: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: rsaKey}))
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: otherKey}))
+
+	keys := func(signers []ssh.Signer) []string {
+		fingerprints := make([]string, 0, len(signers))
+		for _, signer := range signers {
+			fingerprints = append(fingerprints, ssh.FingerprintSHA256(signer.PublicKey()))
+		}
+		return fingerprints
+	}
+	rsaFingerprint := ssh.FingerprintSHA256(rsaSigner.PublicKey())
+	otherFingerprint := ssh.FingerprintSHA256(otherSigner.PublicKey())
+
+	// configured keys first, agent keys are not offered twice
+	signers, err := publicKeysCallback([]ssh.Signer{rsaSigner}, keyring, nil, &AuthConfigError{})()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rsaFingerprint, otherFingerprint}, keys(signers))
+
+	// identities only
+	signers, err = publicKeysCallback(nil, keyring, []ssh.PublicKey{otherSigner.PublicKey()}, &AuthConfigError{})()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{otherFingerprint}, keys(signers))
+
+	signers, err = publicKeysCallback(nil, keyring, []ssh.PublicKey{}, &AuthConfigError{})()
+	assert.NoError(t, err)
+	assert.Empty(t, signers)
+
+	// no agent
+	signers, err = publicKeysCallback([]ssh.Signer{rsaSigner}, nil, nil, &AuthConfigError{})()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rsaFingerprint}, keys(signers))
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return buf
+}
+
+func TestRunCommandWithAgent(t *testing.T) {
+	rsaKey, err := ssh.ParseRawPrivateKey(mustReadFile(t, "./tests/.ssh/id_rsa"))
+	if err != nil {
+		t.Fatalf("ParseRawPrivateKey: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: rsaKey}))
+
+	ssh := &MakeConfig{
+		Server:         "localhost",
+		User:           "drone-scp",
+		Port:           "22",
+		KeyPath:        "./tests/.ssh/id_rsa.pub",
+		AgentSocket:    serveTestAgent(t, keyring),
+		IdentitiesOnly: true,
+	}
+
+	outStr, _, _, err := ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	// the agent holds no key for the configured public key
+	ssh.KeyPath = "./tests/.ssh/test.pub"
+	_, _, _, err = ssh.Run("whoami")
+	assert.Error(t, err)
+
+	// the agent is disabled
+	ssh.KeyPath = "./tests/.ssh/id_rsa.pub"
+	ssh.DisableAgent = true
+	_, _, _, err = ssh.Run("whoami")
+	assert.Error(t, err)
+
+	// the agent socket does not exist
+	ssh.DisableAgent = false
+	ssh.AgentSocket = filepath.Join(t.TempDir(), "missing.sock")
+	_, _, _, err = ssh.Run("whoami")
+	assert.Error(t, err)
+}
+
+func TestNewKeyring(t *testing.T) {
+	signer, err := getKeyFile("./tests/.ssh/test", "1234")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+	cert := newTestCertificate(t, signer.PublicKey(), ssh.UserCert, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	keyring, err := newKeyring(DefaultConfig{
+		KeyPath:    "./tests/.ssh/test",
+		Passphrase: "1234",
+		Cert:       string(ssh.MarshalAuthorizedKey(cert)),
+	})
+	assert.NoError(t, err)
+
+	keys, err := keyring.List()
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, signer.PublicKey().Marshal(), keys[0].Marshal())
+		assert.Equal(t, cert.Marshal(), keys[1].Marshal())
+	}
+
+	// public keys are left out
+	keyring, err = newKeyring(DefaultConfig{KeyPath: "./tests/.ssh/id_rsa.pub"})
+	assert.NoError(t, err)
+	keys, err = keyring.List()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestForwardAgent(t *testing.T) {
+	publicKey := strings.Fields(string(mustReadFile(t, "./tests/.ssh/id_rsa.pub")))[1]
+
+	// in-process agent holding KeyPath
+	ssh := &MakeConfig{
+		Server:       "localhost",
+		User:         "drone-scp",
+		Port:         "22",
+		KeyPath:      "./tests/.ssh/id_rsa",
+		DisableAgent: true,
+		ForwardAgent: true,
+	}
+	outStr, _, _, err := ssh.Run("ssh-add -L")
+	assert.NoError(t, err)
+	assert.Contains(t, outStr, publicKey)
+
+	// local agent
+	testKey, err := sshkeys.ParseEncryptedRawPrivateKey(mustReadFile(t, "./tests/.ssh/test"), []byte("1234"))
+	if err != nil {
+		t.Fatalf("ParseEncryptedRawPrivateKey: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: testKey}))
+	ssh.DisableAgent = false
+	ssh.AgentSocket = serveTestAgent(t, keyring)
+	outStr, _, _, err = ssh.Run("ssh-add -L")
+	assert.NoError(t, err)
+	assert.Contains(t, outStr, strings.Fields(string(mustReadFile(t, "./tests/.ssh/test.pub")))[1])
+	assert.NotContains(t, outStr, publicKey)
+
+	// a stale SSH_AUTH_SOCK falls back to the in-process agent
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+	ssh.AgentSocket = ""
+	outStr, _, _, err = ssh.Run("ssh-add -L")
+	assert.NoError(t, err)
+	assert.Contains(t, outStr, publicKey)
+
+	// not forwarded
+	ssh.ForwardAgent = false
+	_, _, _, err = ssh.Run("ssh-add -L")
+	assert.Error(t, err)
+}
+
+func TestAuthConfigError(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:   "localhost",
+		User:     "drone-scp",
+		Port:     "22",
+		Password: "123456",
+		KeyPath:  "./tests/.ssh/missing",
+		Key:      "not a key",
+	}
+
+	// the handshake error carries the failures
+	_, _, _, err := ssh.Run("whoami")
+	var authErr *AuthConfigError
+	if assert.True(t, errors.As(err, &authErr)) {
+		assert.Equal(t, "localhost", authErr.Server)
+		if assert.Len(t, authErr.Failures, 2) {
+			assert.Equal(t, "KeyPath ./tests/.ssh/missing", authErr.Failures[0].Source)
+			assert.Equal(t, "Key", authErr.Failures[1].Source)
+		}
+	}
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "unable to authenticate")
+
+	// the remaining sources are still used
+	ssh.Password = "1234"
+	outStr, _, _, err := ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	// strict mode fails before dialing
+	ssh.StrictAuth = true
+	ssh.Server = "unreachable.invalid"
+	session, client, err := ssh.Connect()
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.True(t, errors.As(err, &authErr))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// host key failures do not carry them
+	ssh.StrictAuth = false
+	ssh.Fingerprint = "SHA256:" + strings.Repeat("A", 43)
+	_, _, err = ssh.Connect()
+	assert.ErrorIs(t, err, ErrFingerprintMismatch)
+	assert.False(t, errors.As(err, &authErr))
+
+	// dial errors are left alone
+	ssh.Fingerprint = ""
+	ssh.Port = "1"
+	ssh.Server = "localhost"
+	_, _, err = ssh.Connect()
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &authErr))
+}
+
+func TestPublicKeyOnly(t *testing.T) {
+	keyring := agent.NewKeyring()
+	publicKey := string(mustReadFile(t, "./tests/.ssh/id_rsa.pub"))
+
+	// the public key is reported unless it selects the agent keys
+	for _, config := range []DefaultConfig{
+		{KeyPath: "./tests/.ssh/id_rsa.pub", DisableAgent: true},
+		{Key: publicKey, AgentSocket: serveTestAgent(t, keyring)},
+		{Key: publicKey, IdentitiesOnly: true, DisableAgent: true},
+	} {
+		config.Server = "localhost"
+		config.User = "drone-scp"
+		_, closer, authErr, err := getSSHConfig(config)
+		assert.NoError(t, err)
+		if closer != nil {
+			_ = closer.Close()
+		}
+		if assert.Len(t, authErr.Failures, 1) {
+			assert.ErrorIs(t, authErr.Failures[0].Err, ErrPublicKeyOnly)
+		}
+
+		config.StrictAuth = true
+		_, _, _, err = getSSHConfig(config)
+		assert.ErrorIs(t, err, ErrPublicKeyOnly)
+	}
+
+	_, closer, authErr, err := getSSHConfig(DefaultConfig{
+		Server:         "localhost",
+		User:           "drone-scp",
+		KeyPath:        "./tests/.ssh/id_rsa.pub",
+		AgentSocket:    serveTestAgent(t, keyring),
+		IdentitiesOnly: true,
+		StrictAuth:     true,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, authErr.Failures)
+	_ = closer.Close()
+}
+
+func TestAuthConfigErrorWrap(t *testing.T) {
+	authErr := &AuthConfigError{Server: "localhost"}
+	authErr.add("Key", ErrPublicKeyOnly)
+	err := handshakeError{errors.New("ssh: handshake failed")}
+
+	// host key failures happen before authentication
+	assert.Equal(t, error(err), authErr.wrap(err))
+
+	accept := authErr.hostKeyCallback(ssh.InsecureIgnoreHostKey())
+	assert.NoError(t, accept("localhost:22", nil, nil))
+	assert.ErrorIs(t, authErr.wrap(err), ErrPublicKeyOnly)
+
+	// dial errors are left alone
+	dialErr := errors.New("connection refused")
+	assert.Equal(t, dialErr, authErr.wrap(dialErr))
+}
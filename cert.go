@@ -0,0 +1,84 @@
+package easyssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrNotUserCertificate is returned when Cert or CertPath does not hold an
+	// OpenSSH user certificate.
+	ErrNotUserCertificate = errors.New("easyssh: not an ssh user certificate")
+	// ErrCertificateKeyMismatch is returned when the certificate does not
+	// certify the public key of any configured private key.
+	ErrCertificateKeyMismatch = errors.New("easyssh: certificate does not match the private key")
+	// ErrCertificateNotYetValid is returned when the validity period of the
+	// certificate has not started yet.
+	ErrCertificateNotYetValid = errors.New("easyssh: certificate is not yet valid")
+	// ErrCertificateExpired is returned when the validity period of the
+	// certificate is over.
+	ErrCertificateExpired = errors.New("easyssh: certificate has expired")
+)
+
+// getCertificate returns the user certificate configured through Cert or
+// CertPath, or nil when none is configured.
+func getCertificate(config DefaultConfig) (*ssh.Certificate, error) {
+	buf := []byte(config.Cert)
+	if config.Cert == "" {
+		if config.CertPath == "" {
+			return nil, nil
+		}
+		var err error
+		if buf, err = os.ReadFile(config.CertPath); err != nil {
+			return nil, err
+		}
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil, fmt.Errorf("easyssh: parse certificate: %w", err)
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, ErrNotUserCertificate
+	}
+
+	return cert, nil
+}
+
+// checkCertificate reports why cert can not be used at time now, if at all.
+// The principals are left to the server, which may map them to the login
+// user with AuthorizedPrincipalsFile or AuthorizedPrincipalsCommand.
+func checkCertificate(cert *ssh.Certificate, now time.Time) error {
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("%w: valid after %s", ErrCertificateNotYetValid, certTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("%w: valid before %s", ErrCertificateExpired, certTime(cert.ValidBefore))
+	}
+	return nil
+}
+
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+// certSigner pairs cert with the signer holding the private key it certifies.
+func certSigner(cert *ssh.Certificate, signers []ssh.Signer) (ssh.Signer, error) {
+	certified := cert.Key.Marshal()
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), certified) {
+			return ssh.NewCertSigner(cert, signer)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: certificate is for %s key %s",
+		ErrCertificateKeyMismatch, cert.Key.Type(), ssh.FingerprintSHA256(cert.Key))
+}
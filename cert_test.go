@@ -0,0 +1,128 @@
+package easyssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestCertificate signs a user certificate for key with a throwaway CA.
+func newTestCertificate(t *testing.T, key ssh.PublicKey, certType uint32, validAfter, validBefore time.Time, principals ...string) *ssh.Certificate {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert: %v", err)
+	}
+	return cert
+}
+
+func TestGetCertificate(t *testing.T) {
+	signer, err := getKeyFile("./tests/.ssh/id_rsa", "")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+
+	// no certificate
+	cert, err := getCertificate(DefaultConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, cert)
+
+	userCert := newTestCertificate(t, signer.PublicKey(), ssh.UserCert, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	cert, err = getCertificate(DefaultConfig{Cert: string(ssh.MarshalAuthorizedKey(userCert))})
+	assert.NoError(t, err)
+	assert.Equal(t, userCert.Marshal(), cert.Marshal())
+
+	certPath := filepath.Join(t.TempDir(), "id_rsa-cert.pub")
+	if err := os.WriteFile(certPath, ssh.MarshalAuthorizedKey(userCert), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cert, err = getCertificate(DefaultConfig{CertPath: certPath})
+	assert.NoError(t, err)
+	assert.Equal(t, userCert.Marshal(), cert.Marshal())
+
+	// missing file
+	_, err = getCertificate(DefaultConfig{CertPath: "abc"})
+	assert.Error(t, err)
+
+	// plain public key
+	_, err = getCertificate(DefaultConfig{Cert: string(ssh.MarshalAuthorizedKey(signer.PublicKey()))})
+	assert.ErrorIs(t, err, ErrNotUserCertificate)
+
+	// host certificate
+	hostCert := newTestCertificate(t, signer.PublicKey(), ssh.HostCert, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	_, err = getCertificate(DefaultConfig{Cert: string(ssh.MarshalAuthorizedKey(hostCert))})
+	assert.ErrorIs(t, err, ErrNotUserCertificate)
+}
+
+func TestCheckCertificate(t *testing.T) {
+	signer, err := getKeyFile("./tests/.ssh/id_rsa", "")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+	now := time.Now()
+
+	cert := newTestCertificate(t, signer.PublicKey(), ssh.UserCert, now.Add(-time.Hour), now.Add(time.Hour), "drone-scp")
+	assert.NoError(t, checkCertificate(cert, now))
+	assert.ErrorIs(t, checkCertificate(cert, now.Add(2*time.Hour)), ErrCertificateExpired)
+	assert.ErrorIs(t, checkCertificate(cert, now.Add(-2*time.Hour)), ErrCertificateNotYetValid)
+
+	// no expiry
+	cert.ValidBefore = ssh.CertTimeInfinity
+	assert.NoError(t, checkCertificate(cert, now.Add(24*time.Hour)))
+}
+
+func TestCertSigner(t *testing.T) {
+	rsaSigner, err := getKeyFile("./tests/.ssh/id_rsa", "")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+	otherSigner, err := getKeyFile("./tests/.ssh/test", "1234")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+
+	cert := newTestCertificate(t, rsaSigner.PublicKey(), ssh.UserCert, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	signer, err := certSigner(cert, []ssh.Signer{otherSigner, rsaSigner})
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Marshal(), signer.PublicKey().Marshal())
+
+	_, err = certSigner(cert, []ssh.Signer{otherSigner})
+	assert.ErrorIs(t, err, ErrCertificateKeyMismatch)
+
+	// Connect fails before dialing
+	sshConf := &MakeConfig{
+		Server:     "localhost",
+		User:       "drone-scp",
+		Port:       "22",
+		KeyPath:    "./tests/.ssh/test",
+		Passphrase: "1234",
+		Cert:       string(ssh.MarshalAuthorizedKey(cert)),
+	}
+	session, client, err := sshConf.Connect()
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.ErrorIs(t, err, ErrCertificateKeyMismatch)
+}
@@ -0,0 +1,346 @@
+package easyssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Client is a long-lived SSH connection returned by MakeConfig.Dial.
+// Every operation opens a fresh ssh.Session on the shared connection, so
+// many commands and file transfers can be run against the same host without
+// dialing and authenticating again. Close must be called when the Client is
+// no longer used.
+type Client struct {
+	config  *MakeConfig
+	client  *ssh.Client
+	proxies []*ssh.Client
+
+	// stopping counts the commands being stopped in the background, see
+	// remoteProcess.stop. stopMu guards stopCount and closing, which is set
+	// once Close waits for them, so that no command is added meanwhile.
+	stopping  sync.WaitGroup
+	stopMu    sync.Mutex
+	stopCount int
+	closing   bool
+}
+
+// NewSession opens a new session on the underlying connection, requesting a
+// pseudo-terminal when MakeConfig.Pty or MakeConfig.RequestPty is set and
+// agent forwarding when MakeConfig.ForwardAgent is set.
+func (c *Client) NewSession() (*ssh.Session, error) {
+	return c.newSession(c.config.pty())
+}
+
+// newSession opens a new session, requesting pty when it is not nil.
+func (c *Client) newSession(pty *PtyOptions) (*ssh.Session, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.config.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+	}
+
+	// Request a pseudo-terminal if this option is set
+	if pty != nil {
+		if err := session.RequestPty(pty.Term, pty.Height, pty.Width, pty.Modes); err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// Close closes the connection to the remote server and, when the connection
+// was made through proxies, every connection of the proxy chain as well.
+// Commands that timed out and are still being stopped are waited for first,
+// for up to MakeConfig.KillGracePeriod and the time given to SIGKILL, so
+// that the signals still reach them.
+func (c *Client) Close() error {
+	c.stopMu.Lock()
+	c.closing = true
+	c.stopMu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.stopping.Wait()
+		close(stopped)
+	}()
+	timer := time.NewTimer(c.config.killGracePeriod() + killWait)
+	select {
+	case <-stopped:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	err := c.client.Close()
+	for i := len(c.proxies) - 1; i >= 0; i-- {
+		_ = c.proxies[i].Close()
+	}
+	return err
+}
+
+// release closes a connection dialed for a single command. While commands
+// are being stopped in the background it is closed in the background as
+// well, see Close.
+func (c *Client) release() error {
+	c.stopMu.Lock()
+	stopping := c.stopCount > 0
+	c.stopMu.Unlock()
+	if !stopping {
+		return c.Close()
+	}
+	go func() { _ = c.Close() }()
+	return nil
+}
+
+// Stream runs command in a new session. See MakeConfig.Stream for the
+// meaning of the returned channels.
+func (c *Client) Stream(command string, timeout ...time.Duration) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout(timeout))
+	return c.stream(ctx, command, c.options(nil), cancel)
+}
+
+// StreamContext is like Stream but the command is bound to ctx instead of a
+// timeout, and configured by opts such as WithStdin. When ctx is done the
+// command is stopped, see MakeConfig.KillGracePeriod, reported as timed out
+// and its session is closed; the Client itself stays open.
+func (c *Client) StreamContext(ctx context.Context, command string, opts ...ExecOption) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	return c.stream(ctx, command, c.options(opts), func() {})
+}
+
+// stream runs command in a new session with the options o until it
+// finishes or ctx is done, then calls release.
+func (c *Client) stream(ctx context.Context, command string, o execOptions, release func()) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	// continuously send the command's output over the channel
+	stdoutChan := make(chan string)
+	stderrChan := make(chan string)
+	doneChan := make(chan bool)
+	errChan := make(chan error)
+
+	session, err := c.NewSession()
+	if err != nil {
+		release()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			_ = session.Close()
+			release()
+		})
+	}
+
+	// the output goes through pipes rather than the session pipes so that
+	// start can filter it
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	session.Stdout = outWriter
+	session.Stderr = errWriter
+	p, err := c.start(session, command, o)
+	if err != nil {
+		closeBoth()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+	go func() {
+		<-p.exited
+		_ = outWriter.Close()
+		_ = errWriter.Close()
+	}()
+
+	bufSize := c.config.ReadBuffSize
+	if bufSize <= 0 {
+		bufSize = defaultBufferSize
+	}
+	stdoutScanner := bufio.NewReaderSize(outReader, bufSize)
+	stderrScanner := bufio.NewReaderSize(errReader, bufSize)
+
+	go func() {
+		defer close(doneChan)
+		defer close(errChan)
+		defer closeBoth()
+
+		scan := func(r *bufio.Reader, out chan<- string) {
+			defer close(out)
+			for {
+				text, readErr := r.ReadString('\n')
+				if text != "" {
+					select {
+					case out <- strings.TrimRight(text, "\n"):
+					case <-ctx.Done():
+						return
+					}
+				}
+				if readErr != nil {
+					return
+				}
+			}
+		}
+
+		var resWg sync.WaitGroup
+		resWg.Add(2)
+		go func() { defer resWg.Done(); scan(stdoutScanner, stdoutChan) }()
+		go func() { defer resWg.Done(); scan(stderrScanner, stderrChan) }()
+
+		res := make(chan struct{}, 1)
+		go func() {
+			resWg.Wait()
+			res <- struct{}{}
+		}()
+
+		select {
+		case <-res:
+			<-p.exited
+			err := p.err
+			if readErr := p.stdinErr(); readErr != nil {
+				err = readErr
+			}
+			errChan <- err
+			doneChan <- true
+		case <-ctx.Done():
+			// Stop the command right away rather than once the caller has
+			// drained the channels, which are no longer read. The session
+			// is closed once it is stopped.
+			_ = outReader.Close()
+			_ = errReader.Close()
+			p.stop()
+			closeOnce.Do(release)
+			errChan <- fmt.Errorf("Run Command Timeout: %w", ctx.Err())
+			doneChan <- false
+		}
+	}()
+
+	return stdoutChan, stderrChan, doneChan, errChan, err
+}
+
+// Run runs command in a new session and returns its output. See
+// MakeConfig.Run for the meaning of the returned values.
+func (c *Client) Run(command string, timeout ...time.Duration) (outStr string, errStr string, isTimeout bool, err error) {
+	stdoutChan, stderrChan, doneChan, errChan, err := c.Stream(command, timeout...)
+	if err != nil {
+		return outStr, errStr, isTimeout, err
+	}
+
+	return readStream(stdoutChan, stderrChan, doneChan, errChan)
+}
+
+// RunContext is like Run but the command is bound to ctx instead of a
+// timeout, and configured by opts such as WithStdin.
+func (c *Client) RunContext(ctx context.Context, command string, opts ...ExecOption) (outStr string, errStr string, isTimeout bool, err error) {
+	stdoutChan, stderrChan, doneChan, errChan, err := c.StreamContext(ctx, command, opts...)
+	if err != nil {
+		return outStr, errStr, isTimeout, err
+	}
+
+	return readStream(stdoutChan, stderrChan, doneChan, errChan)
+}
+
+// WriteFile reads size bytes from the reader and writes them to a file on the remote machine
+func (c *Client) WriteFile(reader io.Reader, size int64, etargetFile string) error {
+	return c.WriteFileContext(context.Background(), reader, size, etargetFile)
+}
+
+// WriteFileContext is like WriteFile but aborts the transfer and closes its
+// session as soon as ctx is done.
+func (c *Client) WriteFileContext(ctx context.Context, reader io.Reader, size int64, etargetFile string) error {
+	if err := checkTargetFile(etargetFile); err != nil {
+		return err
+	}
+
+	return c.writeFile(ctx, reader, size, etargetFile)
+}
+
+func (c *Client) writeFile(ctx context.Context, reader io.Reader, size int64, etargetFile string) error {
+	targetFile := filepath.Base(etargetFile)
+
+	session, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+
+	// Closing the session unblocks both the remote scp and the copy below.
+	stop := context.AfterFunc(ctx, func() { _ = session.Close() })
+	defer stop()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	copyF := func() error {
+		_, err := fmt.Fprintln(w, "C0644", size, targetFile)
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			_, err = io.Copy(w, reader)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = fmt.Fprint(w, "\x00")
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	copyErrC := make(chan error, 1)
+	go func() {
+		defer func() { _ = w.Close() }()
+		copyErrC <- copyF()
+	}()
+
+	err = session.Run("scp -tr " + shellQuote(etargetFile))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	err = <-copyErrC
+	return err
+}
+
+// Scp uploads sourceFile to remote machine like native scp console app.
+func (c *Client) Scp(sourceFile string, etargetFile string) error {
+	return c.ScpContext(context.Background(), sourceFile, etargetFile)
+}
+
+// ScpContext is like Scp but aborts the transfer as soon as ctx is done.
+func (c *Client) ScpContext(ctx context.Context, sourceFile string, etargetFile string) error {
+	src, srcErr := os.Open(sourceFile)
+
+	if srcErr != nil {
+		return srcErr
+	}
+	defer func() { _ = src.Close() }()
+
+	srcStat, statErr := src.Stat()
+
+	if statErr != nil {
+		return statErr
+	}
+	return c.WriteFileContext(ctx, src, srcStat.Size(), etargetFile)
+}
@@ -0,0 +1,84 @@
+package easyssh
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientReusesConnection(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	client, err := ssh.Dial()
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for i := 0; i < 3; i++ {
+		outStr, errStr, isTimeout, err := client.Run("whoami")
+		assert.Equal(t, "drone-scp\n", outStr)
+		assert.Equal(t, "", errStr)
+		assert.True(t, isTimeout)
+		assert.NoError(t, err)
+	}
+
+	err = client.WriteFile(strings.NewReader("client"), 6, "client.txt")
+	assert.NoError(t, err)
+
+	err = client.Scp("./tests/a.txt", "a.txt")
+	assert.NoError(t, err)
+
+	u, err := user.Lookup("drone-scp")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+
+	// check file exist
+	if _, err := os.Stat(path.Join(u.HomeDir, "client.txt")); os.IsNotExist(err) {
+		t.Fatalf("SCP-error: %v", err)
+	}
+}
+
+func TestClientThroughProxy(t *testing.T) {
+	ssh := &MakeConfig{
+		User:    "drone-scp",
+		Server:  "localhost",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+		Proxy: DefaultConfig{
+			User:    "drone-scp",
+			Server:  "localhost",
+			Port:    "22",
+			KeyPath: "./tests/.ssh/id_rsa",
+		},
+	}
+
+	client, err := ssh.Dial()
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	outStr, _, _, err := client.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	outStr, _, _, err = client.Run("echo again")
+	assert.Equal(t, "again\n", outStr)
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Close())
+
+	// the connection is gone after Close
+	_, err = client.NewSession()
+	assert.Error(t, err)
+}
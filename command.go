@@ -0,0 +1,138 @@
+package easyssh
+
+import (
+	"strings"
+	"time"
+)
+
+// Cmd is a command line for a POSIX shell built from separate arguments,
+// which are quoted so that spaces, quotes and other shell syntax in them are
+// passed literally. RunCmd and ExecCmd run it, and its String method
+// returns the command line to give to the other methods, such as Stream and
+// RunWithWriters:
+//
+//	cmd := easyssh.Command("grep", "-r", pattern, dir).
+//		Pipe("sort", "-u").
+//		RedirectStdout("/tmp/matches.txt").
+//		And("wc", "-l", "/tmp/matches.txt")
+//	stdout, stderr, isTimeout, err := ssh.RunCmd(cmd)
+//
+// Redirections apply to the last command added.
+type Cmd struct {
+	parts []string
+}
+
+// Command returns a Cmd running name with args.
+func Command(name string, args ...string) *Cmd {
+	return (&Cmd{}).add("", name, args)
+}
+
+// Pipe connects the standard output of the command line to the standard
+// input of name (|).
+func (c *Cmd) Pipe(name string, args ...string) *Cmd {
+	return c.add("|", name, args)
+}
+
+// And runs name when the command line succeeds (&&).
+func (c *Cmd) And(name string, args ...string) *Cmd {
+	return c.add("&&", name, args)
+}
+
+// Or runs name when the command line fails (||).
+func (c *Cmd) Or(name string, args ...string) *Cmd {
+	return c.add("||", name, args)
+}
+
+// Then runs name after the command line, whether it succeeds or not (;).
+func (c *Cmd) Then(name string, args ...string) *Cmd {
+	return c.add(";", name, args)
+}
+
+// RedirectStdout writes the standard output of the last command to path
+// (>).
+func (c *Cmd) RedirectStdout(path string) *Cmd {
+	return c.redirect(">", path)
+}
+
+// AppendStdout appends the standard output of the last command to path
+// (>>).
+func (c *Cmd) AppendStdout(path string) *Cmd {
+	return c.redirect(">>", path)
+}
+
+// RedirectStderr writes the standard error of the last command to path
+// (2>).
+func (c *Cmd) RedirectStderr(path string) *Cmd {
+	return c.redirect("2>", path)
+}
+
+// StderrToStdout sends the standard error of the last command to its
+// standard output (2>&1).
+func (c *Cmd) StderrToStdout() *Cmd {
+	c.parts = append(c.parts, "2>&1")
+	return c
+}
+
+// RedirectStdin reads the standard input of the last command from path (<).
+func (c *Cmd) RedirectStdin(path string) *Cmd {
+	return c.redirect("<", path)
+}
+
+// String returns the quoted command line.
+func (c *Cmd) String() string {
+	return strings.Join(c.parts, " ")
+}
+
+// RunCmd is like Run with the command line of cmd.
+func (ssh_conf *MakeConfig) RunCmd(cmd *Cmd, timeout ...time.Duration) (outStr string, errStr string, isTimeout bool, err error) {
+	return ssh_conf.Run(cmd.String(), timeout...)
+}
+
+// ExecCmd is like Exec with the command line of cmd.
+func (ssh_conf *MakeConfig) ExecCmd(cmd *Cmd, timeout ...time.Duration) (*Result, error) {
+	return ssh_conf.Exec(cmd.String(), timeout...)
+}
+
+// RunCmd is like Run with the command line of cmd.
+func (c *Client) RunCmd(cmd *Cmd, timeout ...time.Duration) (outStr string, errStr string, isTimeout bool, err error) {
+	return c.Run(cmd.String(), timeout...)
+}
+
+// ExecCmd is like Exec with the command line of cmd.
+func (c *Client) ExecCmd(cmd *Cmd, timeout ...time.Duration) (*Result, error) {
+	return c.Exec(cmd.String(), timeout...)
+}
+
+func (c *Cmd) add(operator, name string, args []string) *Cmd {
+	if operator != "" {
+		c.parts = append(c.parts, operator)
+	}
+	c.parts = append(c.parts, Quote(name))
+	for _, arg := range args {
+		c.parts = append(c.parts, Quote(arg))
+	}
+	return c
+}
+
+func (c *Cmd) redirect(operator, path string) *Cmd {
+	c.parts = append(c.parts, operator, Quote(path))
+	return c
+}
+
+// Quote quotes s for a POSIX shell, so that it is read as a single word with
+// its exact content. Words made only of letters, digits and the characters
+// @%+:,./_- are returned unchanged.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("@%+:,./_-", r):
+		default:
+			return shellQuote(s)
+		}
+	}
+	return s
+}
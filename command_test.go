@@ -0,0 +1,77 @@
+package easyssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"":                     "''",
+		"grep":                 "grep",
+		"/var/log/app-1.log":   "/var/log/app-1.log",
+		"user@host:22,x%+":     "user@host:22,x%+",
+		"two words":            "'two words'",
+		"it's":                 `'it'\''s'`,
+		"$(reboot)":            "'$(reboot)'",
+		"*.log":                "'*.log'",
+		"--opt=value":          "'--opt=value'",
+		"~root":                "'~root'",
+		"a;b|c&d>e<f\nnewline": "'a;b|c&d>e<f\nnewline'",
+	} {
+		assert.Equal(t, want, Quote(s), s)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	assert.Equal(t, "ls", Command("ls").String())
+
+	cmd := Command("grep", "-r", "it's here", "/srv/my app").
+		Pipe("sort", "-u").
+		RedirectStdout("/tmp/out file").
+		StderrToStdout().
+		And("echo", "done").
+		Or("echo", "failed; rm -rf /").
+		RedirectStderr("/dev/null").
+		Then("cat").
+		RedirectStdin("in").
+		AppendStdout("log")
+	assert.Equal(t, `grep -r 'it'\''s here' '/srv/my app' | sort -u > '/tmp/out file' 2>&1`+
+		` && echo done || echo 'failed; rm -rf /' 2> /dev/null ; cat < in >> log`, cmd.String())
+}
+
+func TestRunCommandBuilder(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	dir := "/tmp/easyssh it's \"quoted\" $(touch pwned)"
+	file := dir + "/a file;id"
+	cmd := Command("mkdir", "-p", dir).
+		And("printf", `%s\n`, "needle 'one'", "hay", "needle $two").RedirectStdout(file).
+		And("grep", "-c", "needle", file).
+		And("ls", dir).
+		Then("rm", "-rf", dir)
+
+	outStr, errStr, _, err := sshConf.RunCmd(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, "2\na file;id\n", outStr)
+	assert.Empty(t, errStr)
+
+	res, err := sshConf.ExecCmd(Command("ls", "pwned"))
+	assert.Error(t, err)
+	assert.NotEqual(t, 0, res.ExitCode)
+
+	c, err := sshConf.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+	res, err = c.ExecCmd(Command("printf", "%s", "it's $HOME"))
+	assert.NoError(t, err)
+	assert.Equal(t, "it's $HOME", res.Stdout)
+}
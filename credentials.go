@@ -0,0 +1,83 @@
+package easyssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignerProvider provides keys for public key authentication, for instance
+// from a secret store. It is asked for them every time a connection is set
+// up.
+type SignerProvider interface {
+	Signers() ([]ssh.Signer, error)
+}
+
+// SignerFunc adapts a function to a SignerProvider.
+type SignerFunc func() ([]ssh.Signer, error)
+
+// Signers calls f.
+func (f SignerFunc) Signers() ([]ssh.Signer, error) {
+	return f()
+}
+
+// SecretSource provides a password or passphrase. It is asked for the secret
+// every time a connection is set up, so the secret does not have to be kept
+// in the configuration.
+type SecretSource interface {
+	Secret() (string, error)
+}
+
+// SecretFunc adapts a function to a SecretSource.
+type SecretFunc func() (string, error)
+
+// Secret calls f.
+func (f SecretFunc) Secret() (string, error) {
+	return f()
+}
+
+// EnvSecret reads the secret from the environment variable it names.
+type EnvSecret string
+
+// Secret returns the value of the environment variable, which must be set.
+func (e EnvSecret) Secret() (string, error) {
+	secret, ok := os.LookupEnv(string(e))
+	if !ok {
+		return "", fmt.Errorf("easyssh: environment variable %s is not set", string(e))
+	}
+	return secret, nil
+}
+
+// FileSecret reads the secret from the file it names.
+type FileSecret string
+
+// Secret returns the content of the file without its trailing newline.
+func (f FileSecret) Secret() (string, error) {
+	buf, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(buf), "\n"), "\r"), nil
+}
+
+// resolveSecrets returns config with Password and Passphrase taken from their
+// sources, if set. Sources that fail are added to authErr.
+func resolveSecrets(config DefaultConfig, authErr *AuthConfigError) DefaultConfig {
+	if config.PasswordSource != nil {
+		if password, err := config.PasswordSource.Secret(); err != nil {
+			authErr.add("PasswordSource", err)
+		} else {
+			config.Password = password
+		}
+	}
+	if config.PassphraseSource != nil {
+		if passphrase, err := config.PassphraseSource.Secret(); err != nil {
+			authErr.add("PassphraseSource", err)
+		} else {
+			config.Passphrase = passphrase
+		}
+	}
+	return config
+}
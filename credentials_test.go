@@ -0,0 +1,118 @@
+package easyssh
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestEnvSecret(t *testing.T) {
+	t.Setenv("EASYSSH_TEST_SECRET", "1234")
+
+	secret, err := EnvSecret("EASYSSH_TEST_SECRET").Secret()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", secret)
+
+	_, err = EnvSecret("EASYSSH_TEST_MISSING").Secret()
+	assert.Error(t, err)
+}
+
+func TestFileSecret(t *testing.T) {
+	dir := t.TempDir()
+	for content, want := range map[string]string{
+		"1234":       "1234",
+		"1234\n":     "1234",
+		"1234\r\n":   "1234",
+		"12 34\n\n":  "12 34\n",
+		"\n":         "",
+		"line\nnext": "line\nnext",
+	} {
+		file := filepath.Join(dir, "secret")
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		secret, err := FileSecret(file).Secret()
+		assert.NoError(t, err)
+		assert.Equal(t, want, secret, "content %q", content)
+	}
+
+	_, err := FileSecret(filepath.Join(dir, "missing")).Secret()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSigners(t *testing.T) {
+	signer, err := getKeyFile("./tests/.ssh/id_rsa", "")
+	if err != nil {
+		t.Fatalf("getKeyFile: %v", err)
+	}
+
+	sshConf := &MakeConfig{
+		Server:       "localhost",
+		User:         "drone-scp",
+		Port:         "22",
+		Signers:      []ssh.Signer{signer},
+		DisableAgent: true,
+	}
+	outStr, _, _, err := sshConf.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	calls := 0
+	sshConf.Signers = nil
+	sshConf.SignerProvider = SignerFunc(func() ([]ssh.Signer, error) {
+		calls++
+		return []ssh.Signer{signer}, nil
+	})
+	outStr, _, _, err = sshConf.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	errStore := errors.New("secret store unavailable")
+	sshConf.SignerProvider = SignerFunc(func() ([]ssh.Signer, error) {
+		return nil, errStore
+	})
+	_, _, _, err = sshConf.Run("whoami")
+	assert.ErrorIs(t, err, errStore)
+}
+
+func TestSecretSources(t *testing.T) {
+	t.Setenv("EASYSSH_TEST_PASSWORD", "1234")
+	passphrase := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passphrase, []byte("1234\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// password
+	ssh := &MakeConfig{
+		Server:         "localhost",
+		User:           "drone-scp",
+		Port:           "22",
+		PasswordSource: EnvSecret("EASYSSH_TEST_PASSWORD"),
+		DisableAgent:   true,
+	}
+	outStr, _, _, err := ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	// passphrase
+	ssh.PasswordSource = nil
+	ssh.KeyPath = "./tests/.ssh/test"
+	ssh.PassphraseSource = FileSecret(passphrase)
+	outStr, _, _, err = ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	// the source fails
+	ssh.PassphraseSource = EnvSecret("EASYSSH_TEST_MISSING")
+	ssh.StrictAuth = true
+	_, _, _, err = ssh.Run("whoami")
+	var authErr *AuthConfigError
+	if assert.True(t, errors.As(err, &authErr)) {
+		assert.Equal(t, "PassphraseSource", authErr.Failures[0].Source)
+	}
+}
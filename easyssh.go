@@ -5,17 +5,14 @@
 package easyssh
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/ScaleFT/sshkeys"
@@ -70,6 +67,61 @@ type (
 		KeyExchanges []string
 		Fingerprint  string
 
+		// HostCAKeys lists the public keys, in authorized_keys format, of
+		// the certificate authorities trusted to sign host certificates.
+		// Host certificates must be valid and list the dialed hostname as
+		// a principal. Plain host keys are then only accepted when they
+		// pass Fingerprint or KnownHosts.
+		HostCAKeys []string
+
+		// Cert holds an OpenSSH user certificate (the content of an
+		// id_*-cert.pub file) and CertPath points to one. The certificate is
+		// paired with the private key from Key or KeyPath it certifies.
+		Cert     string
+		CertPath string
+
+		// Signers are offered for public key authentication after the keys
+		// from Key and KeyPath, followed by those of SignerProvider.
+		Signers        []ssh.Signer
+		SignerProvider SignerProvider
+
+		// PasswordSource and PassphraseSource provide Password and
+		// Passphrase when a connection is set up, so that they do not have to
+		// be kept in the configuration. See EnvSecret and FileSecret.
+		PasswordSource   SecretSource
+		PassphraseSource SecretSource
+
+		// KeyboardInteractive answers keyboard-interactive challenges, such
+		// as one-time password prompts. When it is nil and Password is set,
+		// password prompts are answered with Password, see
+		// PasswordKeyboardInteractive.
+		KeyboardInteractive ssh.KeyboardInteractiveChallenge
+
+		// AgentSocket is the path of the ssh-agent socket, defaulting to
+		// SSH_AUTH_SOCK. DisableAgent turns the agent off. With
+		// IdentitiesOnly the agent only offers the keys matching Key or
+		// KeyPath, which may then hold just the public key. An AgentSocket
+		// that can not be reached fails the connection, while a stale
+		// SSH_AUTH_SOCK is skipped and listed in the *AuthConfigError.
+		AgentSocket    string
+		DisableAgent   bool
+		IdentitiesOnly bool
+
+		// StrictAuth makes Connect fail before dialing with an
+		// *AuthConfigError when an authentication source can not be set up,
+		// such as a missing KeyPath, instead of skipping it.
+		StrictAuth bool
+
+		// KnownHosts lists OpenSSH known_hosts files used to verify the
+		// host key of the server. Hashed hosts and [host]:port entries are
+		// supported, missing files are treated as empty.
+		KnownHosts []string
+		// TrustOnFirstUse appends the key of a host that is not found in
+		// KnownHosts to the first KnownHosts file instead of rejecting it.
+		// A key that differs from a recorded one is always rejected with a
+		// *HostKeyChangedError.
+		TrustOnFirstUse bool
+
 		// Enable the use of insecure ciphers and key exchange methods.
 		// This enables the use of the the following insecure ciphers and key exchange methods:
 		// - aes128-cbc
@@ -81,8 +133,64 @@ type (
 		// Those algorithms are insecure and may allow plaintext data to be recovered by an attacker.
 		UseInsecureCipher bool
 
-		// RequestPty requests a pseudo-terminal from the server.
+		// RequestPty requests a pseudo-terminal from the server, an xterm
+		// of 80 columns and 40 rows. Versions before Pty swapped the two
+		// and requested 40 columns and 80 rows.
 		RequestPty bool
+		// Pty requests a pseudo-terminal with the given options, see
+		// PtyOptions. RequestPty is not needed along with it.
+		Pty *PtyOptions
+
+		// Env sets environment variables for the commands run by Run,
+		// Stream, Exec and RunWithWriters. They are sent as env requests
+		// and, when the server does not accept them (see AcceptEnv in
+		// sshd_config), exported by the command line instead. WithEnv adds
+		// variables for a single command.
+		Env map[string]string
+		// WorkingDir is the remote directory the commands are run in. The
+		// command is not run when it does not exist. WithWorkingDir changes
+		// it for a single command.
+		WorkingDir string
+
+		// KillGracePeriod is how long a command that timed out, or whose
+		// context is done, is given to exit after SIGTERM before it is sent
+		// SIGKILL and its session is closed. It defaults to 5 seconds; a
+		// negative value sends SIGKILL right away. The command is stopped in
+		// the background, the timeout is reported right away. Without
+		// ProcessGroup the signals only reach the process the server
+		// started, usually the shell running the command.
+		KillGracePeriod time.Duration
+		// ProcessGroup runs the commands by sh in their own process group,
+		// with setsid or else the job control of sh, and sends the signals
+		// to the whole group from a separate session. Child processes are
+		// then stopped as well, even by servers that ignore signal requests.
+		// The commands lose their controlling terminal, so it can not be
+		// combined with RequestPty, Pty or Expect, nor with Sudo or
+		// WithSudo, whose commands the login user can not signal. Shell
+		// ignores it.
+		ProcessGroup bool
+
+		// Sudo runs the commands of Run, Stream, Exec, RunWithWriters and
+		// Expect with sudo as another user. The command line is wrapped in
+		// sudo -S with a unique prompt, which is answered with the password
+		// and removed from the output, with or without a pseudo-terminal.
+		// The standard input is held back until sudo has authenticated, and
+		// Env and WorkingDir apply to the command run by sudo.
+		// ErrSudoPassword is returned when the password is missing or
+		// rejected. WithSudo changes it for a single command.
+		Sudo *SudoOptions
+
+		// ForwardAgent forwards an ssh-agent to the sessions opened on the
+		// server, so that commands run there can authenticate with the local
+		// keys. The agent at AgentSocket or SSH_AUTH_SOCK is forwarded or,
+		// when there is none or DisableAgent is set, an in-process agent
+		// holding the keys from Key and KeyPath.
+		ForwardAgent bool
+
+		// ProxyJump lists additional proxy servers to tunnel through, in
+		// order, after Proxy (when Proxy is set). Each hop is dialed through
+		// the previous one and uses its own credentials and settings.
+		ProxyJump []DefaultConfig
 	}
 
 	// DefaultConfig for ssh proxy config
@@ -100,6 +208,61 @@ type (
 		KeyExchanges []string
 		Fingerprint  string
 
+		// HostCAKeys lists the public keys, in authorized_keys format, of
+		// the certificate authorities trusted to sign host certificates.
+		// Host certificates must be valid and list the dialed hostname as
+		// a principal. Plain host keys are then only accepted when they
+		// pass Fingerprint or KnownHosts.
+		HostCAKeys []string
+
+		// Cert holds an OpenSSH user certificate (the content of an
+		// id_*-cert.pub file) and CertPath points to one. The certificate is
+		// paired with the private key from Key or KeyPath it certifies.
+		Cert     string
+		CertPath string
+
+		// Signers are offered for public key authentication after the keys
+		// from Key and KeyPath, followed by those of SignerProvider.
+		Signers        []ssh.Signer
+		SignerProvider SignerProvider
+
+		// PasswordSource and PassphraseSource provide Password and
+		// Passphrase when a connection is set up, so that they do not have to
+		// be kept in the configuration. See EnvSecret and FileSecret.
+		PasswordSource   SecretSource
+		PassphraseSource SecretSource
+
+		// KeyboardInteractive answers keyboard-interactive challenges, such
+		// as one-time password prompts. When it is nil and Password is set,
+		// password prompts are answered with Password, see
+		// PasswordKeyboardInteractive.
+		KeyboardInteractive ssh.KeyboardInteractiveChallenge
+
+		// AgentSocket is the path of the ssh-agent socket, defaulting to
+		// SSH_AUTH_SOCK. DisableAgent turns the agent off. With
+		// IdentitiesOnly the agent only offers the keys matching Key or
+		// KeyPath, which may then hold just the public key. An AgentSocket
+		// that can not be reached fails the connection, while a stale
+		// SSH_AUTH_SOCK is skipped and listed in the *AuthConfigError.
+		AgentSocket    string
+		DisableAgent   bool
+		IdentitiesOnly bool
+
+		// StrictAuth makes Connect fail before dialing with an
+		// *AuthConfigError when an authentication source can not be set up,
+		// such as a missing KeyPath, instead of skipping it.
+		StrictAuth bool
+
+		// KnownHosts lists OpenSSH known_hosts files used to verify the
+		// host key of the server. Hashed hosts and [host]:port entries are
+		// supported, missing files are treated as empty.
+		KnownHosts []string
+		// TrustOnFirstUse appends the key of a host that is not found in
+		// KnownHosts to the first KnownHosts file instead of rejecting it.
+		// A key that differs from a recorded one is always rejected with a
+		// *HostKeyChangedError.
+		TrustOnFirstUse bool
+
 		// Enable the use of insecure ciphers and key exchange methods.
 		// This enables the use of the the following insecure ciphers and key exchange methods:
 		// - aes128-cbc
@@ -136,12 +299,21 @@ func getKeyFile(keypath, passphrase string) (ssh.Signer, error) {
 	return pubkey, nil
 }
 
-// returns *ssh.ClientConfig and io.Closer.
+// returns *ssh.ClientConfig, io.Closer and the authentication sources that
+// could not be set up, which are only returned as error with StrictAuth.
 // if io.Closer is not nil, io.Closer.Close() should be called when
 // *ssh.ClientConfig is no longer used.
-func getSSHConfig(config DefaultConfig) (*ssh.ClientConfig, io.Closer) {
+func getSSHConfig(config DefaultConfig) (*ssh.ClientConfig, io.Closer, *AuthConfigError, error) {
 	var sshAgent io.Closer
 
+	hostKeyCallback, hostKeyAlgorithms, err := getHostKeyCallback(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	authErr := &AuthConfigError{Server: config.Server}
+	config = resolveSecrets(config, authErr)
+
 	// auths holds the detected ssh auth methods
 	auths := []ssh.AuthMethod{}
 
@@ -149,11 +321,23 @@ func getSSHConfig(config DefaultConfig) (*ssh.ClientConfig, io.Closer) {
 	if config.Password != "" {
 		auths = append(auths, ssh.Password(config.Password))
 	}
+
+	// signers holds the private keys offered for public key authentication,
+	// identities the public keys of the configured keys
+	signers := []ssh.Signer{}
+	identities := []ssh.PublicKey{}
+	// a public key is only of use to select the agent keys
+	selectsAgentKeys := config.IdentitiesOnly && agentSocket(config) != ""
 	if config.KeyPath != "" {
-		if pubkey, err := getKeyFile(config.KeyPath, config.Passphrase); err != nil {
-			log.Printf("getKeyFile error: %v\n", err)
+		if pubkey, err := getKeyFile(config.KeyPath, config.Passphrase); err == nil {
+			signers = append(signers, pubkey)
+		} else if publicKey, pubErr := getPublicKeyFile(config.KeyPath); pubErr == nil {
+			identities = append(identities, publicKey)
+			if !selectsAgentKeys {
+				authErr.add("KeyPath "+config.KeyPath, ErrPublicKeyOnly)
+			}
 		} else {
-			auths = append(auths, ssh.PublicKeys(pubkey))
+			authErr.add("KeyPath "+config.KeyPath, err)
 		}
 	}
 
@@ -166,16 +350,80 @@ func getSSHConfig(config DefaultConfig) (*ssh.ClientConfig, io.Closer) {
 			signer, err = ssh.ParsePrivateKey([]byte(config.Key))
 		}
 
-		if err != nil {
-			log.Printf("ssh.ParsePrivateKey: %v\n", err)
+		if err == nil {
+			signers = append(signers, signer)
+		} else if publicKey, _, _, _, pubErr := ssh.ParseAuthorizedKey([]byte(config.Key)); pubErr == nil {
+			identities = append(identities, publicKey)
+			if !selectsAgentKeys {
+				authErr.add("Key", ErrPublicKeyOnly)
+			}
 		} else {
-			auths = append(auths, ssh.PublicKeys(signer))
+			authErr.add("Key", err)
+		}
+	}
+
+	signers = append(signers, config.Signers...)
+	if config.SignerProvider != nil {
+		if provided, err := config.SignerProvider.Signers(); err != nil {
+			authErr.add("SignerProvider", err)
+		} else {
+			signers = append(signers, provided...)
+		}
+	}
+
+	for _, signer := range signers {
+		identities = append(identities, signer.PublicKey())
+	}
+
+	// offer the user certificate ahead of the plain keys
+	cert, err := getCertificate(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cert != nil {
+		if err := checkCertificate(cert, time.Now()); err != nil {
+			return nil, nil, nil, err
+		}
+		signer, err := certSigner(cert, signers)
+		if err != nil {
+			return nil, nil, nil, err
 		}
+		signers = append([]ssh.Signer{signer}, signers...)
 	}
 
-	if sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		sshAgent = sock
-		auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(sock).Signers))
+	if config.StrictAuth && len(authErr.Failures) > 0 {
+		return nil, nil, nil, authErr
+	}
+
+	var agentClient agent.Agent
+	if sock := agentSocket(config); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		switch {
+		case err == nil:
+			sshAgent = conn
+			agentClient = agent.NewClient(conn)
+		case config.AgentSocket != "":
+			return nil, nil, nil, fmt.Errorf("easyssh: dial ssh-agent: %w", err)
+		default:
+			// a stale SSH_AUTH_SOCK leaves the configured keys to authenticate
+			authErr.add("ssh-agent "+sock, err)
+		}
+	}
+
+	// the agent keys share one method with the configured keys, as the
+	// client gives up on a method name once it has failed
+	if len(signers) > 0 || agentClient != nil {
+		var only []ssh.PublicKey
+		if config.IdentitiesOnly {
+			only = identities
+		}
+		auths = append(auths, ssh.PublicKeysCallback(publicKeysCallback(signers, agentClient, only, authErr)))
+	}
+
+	if config.KeyboardInteractive != nil {
+		auths = append(auths, ssh.KeyboardInteractive(config.KeyboardInteractive))
+	} else if config.Password != "" {
+		auths = append(auths, ssh.KeyboardInteractive(PasswordKeyboardInteractive(config.Password)))
 	}
 
 	c := ssh.Config{}
@@ -193,259 +441,314 @@ func getSSHConfig(config DefaultConfig) (*ssh.ClientConfig, io.Closer) {
 		c.KeyExchanges = append(c.KeyExchanges, config.KeyExchanges...)
 	}
 
-	hostKeyCallback := ssh.InsecureIgnoreHostKey()
-	if config.Fingerprint != "" {
-		hostKeyCallback = func(hostname string, remote net.Addr, publicKey ssh.PublicKey) error {
-			if ssh.FingerprintSHA256(publicKey) != config.Fingerprint {
-				return ErrFingerprintMismatch
-			}
-			return nil
-		}
-	}
-
 	return &ssh.ClientConfig{
-		Config:          c,
-		Timeout:         config.Timeout,
-		User:            config.User,
-		Auth:            auths,
-		HostKeyCallback: hostKeyCallback,
-	}, sshAgent
+		Config:            c,
+		Timeout:           config.Timeout,
+		User:              config.User,
+		Auth:              auths,
+		HostKeyCallback:   authErr.hostKeyCallback(hostKeyCallback),
+		HostKeyAlgorithms: hostKeyAlgorithms,
+	}, sshAgent, authErr, nil
 }
 
 // Connect to remote server using MakeConfig struct and returns *ssh.Session
 func (ssh_conf *MakeConfig) Connect() (*ssh.Session, *ssh.Client, error) {
-	var client *ssh.Client
-	var err error
+	return ssh_conf.ConnectContext(context.Background())
+}
 
-	// Default protocol is: tcp.
-	if ssh_conf.Protocol == "" {
-		ssh_conf.Protocol = PROTOCOL_TCP
-	}
-	if ssh_conf.Proxy.Protocol == "" {
-		ssh_conf.Proxy.Protocol = PROTOCOL_TCP
-	}
-
-	targetConfig, closer := getSSHConfig(DefaultConfig{
-		User:              ssh_conf.User,
-		Key:               ssh_conf.Key,
-		KeyPath:           ssh_conf.KeyPath,
-		Passphrase:        ssh_conf.Passphrase,
-		Password:          ssh_conf.Password,
-		Timeout:           ssh_conf.Timeout,
-		Ciphers:           ssh_conf.Ciphers,
-		KeyExchanges:      ssh_conf.KeyExchanges,
-		Fingerprint:       ssh_conf.Fingerprint,
-		UseInsecureCipher: ssh_conf.UseInsecureCipher,
-	})
+// ConnectContext is like Connect but aborts the TCP dial, the proxy dials and
+// the SSH handshakes as soon as ctx is done. Once it has returned, the
+// session and the client, along with the proxy connections, are closed when
+// ctx is done before the client is closed.
+func (ssh_conf *MakeConfig) ConnectContext(ctx context.Context) (*ssh.Session, *ssh.Client, error) {
+	c, err := ssh_conf.DialContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := c.NewSession()
+	if err != nil {
+		_ = c.Close()
+		return nil, nil, err
+	}
+
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			_ = session.Close()
+			_ = c.Close()
+		})
+		go func() {
+			_ = c.client.Wait()
+			stop()
+		}()
+	}
+	return session, c.client, nil
+}
+
+// Dial connects to the remote server, through the proxy hops when any are
+// configured, and returns a Client that keeps the connection open until
+// Client.Close is called.
+func (ssh_conf *MakeConfig) Dial() (*Client, error) {
+	return ssh_conf.DialContext(context.Background())
+}
+
+// DialContext is like Dial but aborts the TCP dial, the proxy dials and the
+// SSH handshakes as soon as ctx is done. Unlike ConnectContext, once it has
+// returned ctx no longer affects the Client, which stays open until
+// Client.Close. The configuration is checked with Validate first.
+func (ssh_conf *MakeConfig) DialContext(ctx context.Context) (*Client, error) {
+	if err := ssh_conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	target := DefaultConfig{
+		User:                ssh_conf.User,
+		Server:              ssh_conf.Server,
+		Port:                ssh_conf.Port,
+		Key:                 ssh_conf.Key,
+		KeyPath:             ssh_conf.KeyPath,
+		Cert:                ssh_conf.Cert,
+		CertPath:            ssh_conf.CertPath,
+		Passphrase:          ssh_conf.Passphrase,
+		Password:            ssh_conf.Password,
+		Signers:             ssh_conf.Signers,
+		SignerProvider:      ssh_conf.SignerProvider,
+		PasswordSource:      ssh_conf.PasswordSource,
+		PassphraseSource:    ssh_conf.PassphraseSource,
+		KeyboardInteractive: ssh_conf.KeyboardInteractive,
+		AgentSocket:         ssh_conf.AgentSocket,
+		DisableAgent:        ssh_conf.DisableAgent,
+		IdentitiesOnly:      ssh_conf.IdentitiesOnly,
+		StrictAuth:          ssh_conf.StrictAuth,
+		Timeout:             ssh_conf.Timeout,
+		Ciphers:             ssh_conf.Ciphers,
+		KeyExchanges:        ssh_conf.KeyExchanges,
+		Fingerprint:         ssh_conf.Fingerprint,
+		KnownHosts:          ssh_conf.KnownHosts,
+		TrustOnFirstUse:     ssh_conf.TrustOnFirstUse,
+		HostCAKeys:          ssh_conf.HostCAKeys,
+		UseInsecureCipher:   ssh_conf.UseInsecureCipher,
+	}
+	targetConfig, closer, targetAuthErr, err := getSSHConfig(target)
+	if err != nil {
+		return nil, err
+	}
 	if closer != nil {
 		defer func() { _ = closer.Close() }()
 	}
 
-	// Enable proxy command
-	if ssh_conf.Proxy.Server != "" {
-		proxyConfig, closer := getSSHConfig(DefaultConfig{
-			User:              ssh_conf.Proxy.User,
-			Key:               ssh_conf.Proxy.Key,
-			KeyPath:           ssh_conf.Proxy.KeyPath,
-			Passphrase:        ssh_conf.Proxy.Passphrase,
-			Password:          ssh_conf.Proxy.Password,
-			Timeout:           ssh_conf.Proxy.Timeout,
-			Ciphers:           ssh_conf.Proxy.Ciphers,
-			KeyExchanges:      ssh_conf.Proxy.KeyExchanges,
-			Fingerprint:       ssh_conf.Proxy.Fingerprint,
-			UseInsecureCipher: ssh_conf.Proxy.UseInsecureCipher,
-		})
-		if closer != nil {
-			defer func() { _ = closer.Close() }()
+	// proxies holds the clients of every hop dialed so far, nearest first.
+	var proxies []*ssh.Client
+	closeProxies := func() {
+		for i := len(proxies) - 1; i >= 0; i-- {
+			_ = proxies[i].Close()
 		}
+	}
 
-		proxyClient, err := ssh.Dial(string(ssh_conf.Proxy.Protocol), net.JoinHostPort(ssh_conf.Proxy.Server, ssh_conf.Proxy.Port), proxyConfig)
+	var via *ssh.Client
+	for _, hop := range ssh_conf.proxyHops() {
+		proxyConfig, closer, authErr, err := getSSHConfig(hop)
 		if err != nil {
-			return nil, nil, err
+			closeProxies()
+			return nil, err
+		}
+		if closer != nil {
+			defer func() { _ = closer.Close() }()
 		}
 
-		// Apply timeout to the connection from proxy to target server
-		timeout := ssh_conf.Timeout
-		if timeout == 0 {
-			timeout = defaultTimeout
+		proxyClient, err := dialClient(ctx, via, hop.Protocol, net.JoinHostPort(hop.Server, hop.Port), proxyConfig, hop.Timeout)
+		if err != nil {
+			closeProxies()
+			return nil, authErr.wrap(err)
 		}
+		proxies = append(proxies, proxyClient)
+		via = proxyClient
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+	client, err := dialClient(ctx, via, ssh_conf.Protocol, net.JoinHostPort(ssh_conf.Server, ssh_conf.Port), targetConfig, ssh_conf.Timeout)
+	if err != nil {
+		closeProxies()
+		return nil, targetAuthErr.wrap(err)
+	}
 
-		type connResult struct {
-			conn net.Conn
-			err  error
+	if ssh_conf.ForwardAgent {
+		if err := forwardAgent(client, target); err != nil {
+			_ = client.Close()
+			closeProxies()
+			return nil, err
 		}
+	}
 
-		connCh := make(chan connResult, 1)
+	if len(proxies) > 0 {
+		// Close the whole proxy chain once the target client is closed by the caller.
 		go func() {
-			conn, err := proxyClient.Dial(string(ssh_conf.Protocol), net.JoinHostPort(ssh_conf.Server, ssh_conf.Port))
-			select {
-			case connCh <- connResult{conn: conn, err: err}:
-				// Successfully sent result
-			case <-ctx.Done():
-				// Context was cancelled, clean up the connection if it was established
-				if conn != nil {
-					_ = conn.Close()
-				}
-			}
+			_ = client.Wait()
+			closeProxies()
 		}()
+	}
 
-		var conn net.Conn
-		select {
-		case result := <-connCh:
-			conn = result.conn
-			err = result.err
-		case <-ctx.Done():
-			_ = proxyClient.Close()
-			return nil, nil, fmt.Errorf("%w: %v", ErrProxyDialTimeout, ctx.Err())
-		}
+	return &Client{
+		config:  ssh_conf,
+		client:  client,
+		proxies: proxies,
+	}, nil
+}
+
+// proxyHops returns the proxy servers to tunnel through, in dial order:
+// Proxy when it is set, followed by every entry of ProxyJump.
+func (ssh_conf *MakeConfig) proxyHops() []DefaultConfig {
+	hops := make([]DefaultConfig, 0, len(ssh_conf.ProxyJump)+1)
+	if ssh_conf.Proxy.Server != "" {
+		hops = append(hops, ssh_conf.Proxy)
+	}
+	return append(hops, ssh_conf.ProxyJump...)
+}
 
+// dialClient opens an SSH connection to addr. When via is nil the connection
+// is made directly, otherwise it is tunneled through the via client and
+// bounded by timeout.
+func dialClient(ctx context.Context, via *ssh.Client, protocol Protocol, addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	// Default protocol is: tcp.
+	if protocol == "" {
+		protocol = PROTOCOL_TCP
+	}
+
+	if via == nil {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		conn, err := dialer.DialContext(ctx, string(protocol), addr)
 		if err != nil {
-			_ = proxyClient.Close()
-			return nil, nil, err
+			return nil, err
 		}
+		return newClient(ctx, conn, addr, config)
+	}
 
-		ncc, chans, reqs, err := ssh.NewClientConn(conn, net.JoinHostPort(ssh_conf.Server, ssh_conf.Port), targetConfig)
-		if err != nil {
-			_ = proxyClient.Close()
-			return nil, nil, err
+	// Apply timeout to the connection from proxy to target server
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type connResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	connCh := make(chan connResult, 1)
+	go func() {
+		conn, err := via.Dial(string(protocol), addr)
+		select {
+		case connCh <- connResult{conn: conn, err: err}:
+			// Successfully sent result
+		case <-dialCtx.Done():
+			// Context was cancelled, clean up the connection if it was established
+			if conn != nil {
+				_ = conn.Close()
+			}
 		}
+	}()
 
-		client = ssh.NewClient(ncc, chans, reqs)
-		// Close the proxy client once the target client is closed by the caller.
-		go func() {
-			_ = client.Wait()
-			_ = proxyClient.Close()
-		}()
-	} else {
-		client, err = ssh.Dial(string(ssh_conf.Protocol), net.JoinHostPort(ssh_conf.Server, ssh_conf.Port), targetConfig)
-		if err != nil {
-			return nil, nil, err
+	var conn net.Conn
+	var err error
+	select {
+	case result := <-connCh:
+		conn = result.conn
+		err = result.err
+	case <-dialCtx.Done():
+		// The caller's context takes precedence over the proxy dial timeout.
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		return nil, fmt.Errorf("%w: %v", ErrProxyDialTimeout, dialCtx.Err())
 	}
 
-	session, err := client.NewSession()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	// Request a pseudo-terminal if this option is set
-	if ssh_conf.RequestPty {
-		modes := ssh.TerminalModes{
-			ssh.ECHO:          0,     // disable echoing
-			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-		}
-		if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-			_ = session.Close()
-			return nil, nil, err
+	return newClient(ctx, conn, addr, config)
+}
+
+// newClient runs the SSH handshake over conn, closing conn to abort it when
+// ctx is done first.
+func newClient(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			_ = ncc.Close()
 		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, handshakeError{err}
 	}
 
-	return session, client, nil
+	return ssh.NewClient(ncc, chans, reqs), nil
 }
 
 // Stream returns one channel that combines the stdout and stderr of the command
 // as it is run on the remote machine, and another that sends true when the
 // command is done. The sessions and channels will then be closed.
+// The output is sent line by line without the newlines; RunWithWriters copies
+// it unchanged instead.
 func (ssh_conf *MakeConfig) Stream(command string, timeout ...time.Duration) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
-	// continuously send the command's output over the channel
-	stdoutChan := make(chan string)
-	stderrChan := make(chan string)
-	doneChan := make(chan bool)
-	errChan := make(chan error)
-
-	session, client, err := ssh_conf.Connect()
+	c, err := ssh_conf.Dial()
 	if err != nil {
-		return stdoutChan, stderrChan, doneChan, errChan, err
+		return make(chan string), make(chan string), make(chan bool), make(chan error), err
 	}
 
-	closeBoth := func() {
-		_ = session.Close()
-		_ = client.Close()
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout(timeout))
+	return c.stream(ctx, command, c.options(nil), func() {
+		cancel()
+		_ = c.release()
+	})
+}
 
-	outReader, err := session.StdoutPipe()
-	if err != nil {
-		closeBoth()
-		return stdoutChan, stderrChan, doneChan, errChan, err
-	}
-	errReader, err := session.StderrPipe()
+// StreamContext is like Stream but the connection and the command are bound
+// to ctx instead of a timeout, and configured by opts such as WithStdin.
+// When ctx is done the command is reported as timed out and the session and
+// connection are closed.
+func (ssh_conf *MakeConfig) StreamContext(ctx context.Context, command string, opts ...ExecOption) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	c, err := ssh_conf.DialContext(ctx)
 	if err != nil {
-		closeBoth()
-		return stdoutChan, stderrChan, doneChan, errChan, err
-	}
-	if err = session.Start(command); err != nil {
-		closeBoth()
-		return stdoutChan, stderrChan, doneChan, errChan, err
+		return make(chan string), make(chan string), make(chan bool), make(chan error), err
 	}
 
-	bufSize := ssh_conf.ReadBuffSize
-	if bufSize <= 0 {
-		bufSize = defaultBufferSize
-	}
-	stdoutScanner := bufio.NewReaderSize(outReader, bufSize)
-	stderrScanner := bufio.NewReaderSize(errReader, bufSize)
+	return c.stream(ctx, command, c.options(opts), func() { _ = c.release() })
+}
 
-	executeTimeout := defaultTimeout
+// executeTimeout returns the optional command timeout passed to Stream and
+// Run, or the default one.
+func executeTimeout(timeout []time.Duration) time.Duration {
 	if len(timeout) > 0 {
-		executeTimeout = timeout[0]
+		return timeout[0]
 	}
+	return defaultTimeout
+}
 
-	go func() {
-		defer close(doneChan)
-		defer close(errChan)
-		defer closeBoth()
-
-		ctxTimeout, cancel := context.WithTimeout(context.Background(), executeTimeout)
-		defer cancel()
-
-		scan := func(r *bufio.Reader, out chan<- string) {
-			defer close(out)
-			for {
-				text, readErr := r.ReadString('\n')
-				if text != "" {
-					select {
-					case out <- strings.TrimRight(text, "\n"):
-					case <-ctxTimeout.Done():
-						return
-					}
-				}
-				if readErr != nil {
-					return
-				}
-			}
-		}
-
-		var resWg sync.WaitGroup
-		resWg.Add(2)
-		go func() { defer resWg.Done(); scan(stdoutScanner, stdoutChan) }()
-		go func() { defer resWg.Done(); scan(stderrScanner, stderrChan) }()
-
-		res := make(chan struct{}, 1)
-		go func() {
-			resWg.Wait()
-			res <- struct{}{}
-		}()
-
-		select {
-		case <-res:
-			errChan <- session.Wait()
-			doneChan <- true
-		case <-ctxTimeout.Done():
-			errChan <- fmt.Errorf("Run Command Timeout: %w", ctxTimeout.Err())
-			doneChan <- false
+// Run command on remote machine and returns its stdout as a string.
+// Despite its name, isTimeout is true when the command finished and false
+// when it timed out; it is kept for compatibility. Exec returns a Result with
+// the exit code, signal and timing instead.
+func (ssh_conf *MakeConfig) Run(command string, timeout ...time.Duration) (outStr string, errStr string, isTimeout bool, err error) {
+	stdoutChan, stderrChan, doneChan, errChan, err := ssh_conf.Stream(command, timeout...)
+	if err != nil {
+		// Check if the error is from a proxy dial timeout
+		if errors.Is(err, ErrProxyDialTimeout) {
+			isTimeout = true
 		}
-	}()
+		return outStr, errStr, isTimeout, err
+	}
 
-	return stdoutChan, stderrChan, doneChan, errChan, err
+	return readStream(stdoutChan, stderrChan, doneChan, errChan)
 }
 
-// Run command on remote machine and returns its stdout as a string
-func (ssh_conf *MakeConfig) Run(command string, timeout ...time.Duration) (outStr string, errStr string, isTimeout bool, err error) {
-	stdoutChan, stderrChan, doneChan, errChan, err := ssh_conf.Stream(command, timeout...)
+// RunContext is like Run but the connection and the command are bound to ctx
+// instead of a timeout, and configured by opts such as WithStdin.
+func (ssh_conf *MakeConfig) RunContext(ctx context.Context, command string, opts ...ExecOption) (outStr string, errStr string, isTimeout bool, err error) {
+	stdoutChan, stderrChan, doneChan, errChan, err := ssh_conf.StreamContext(ctx, command, opts...)
 	if err != nil {
 		// Check if the error is from a proxy dial timeout
 		if errors.Is(err, ErrProxyDialTimeout) {
@@ -453,6 +756,12 @@ func (ssh_conf *MakeConfig) Run(command string, timeout ...time.Duration) (outSt
 		}
 		return outStr, errStr, isTimeout, err
 	}
+
+	return readStream(stdoutChan, stderrChan, doneChan, errChan)
+}
+
+// readStream collects the output of a command started with Stream.
+func readStream(stdoutChan, stderrChan <-chan string, doneChan <-chan bool, errChan <-chan error) (outStr string, errStr string, isTimeout bool, err error) {
 	// read from the output channel until the done signal is passed
 loop:
 	for {
@@ -482,61 +791,35 @@ loop:
 
 // WriteFile reads size bytes from the reader and writes them to a file on the remote machine
 func (ssh_conf *MakeConfig) WriteFile(reader io.Reader, size int64, etargetFile string) error {
-	targetFile := filepath.Base(etargetFile)
-	// Reject characters that would either inject extra SCP control records
-	// (\n, \r) or terminate the filename field early (\x00). The remote-side
-	// scp command is invoked through the user's shell, so etargetFile is
-	// single-quoted below to neutralise shell metacharacters.
-	if strings.ContainsAny(etargetFile, "\x00\n\r") || strings.ContainsAny(targetFile, "\x00\n\r") {
-		return ErrInvalidTargetFile
-	}
+	return ssh_conf.WriteFileContext(context.Background(), reader, size, etargetFile)
+}
 
-	session, client, err := ssh_conf.Connect()
-	if err != nil {
+// WriteFileContext is like WriteFile but aborts the connection and the
+// transfer as soon as ctx is done.
+func (ssh_conf *MakeConfig) WriteFileContext(ctx context.Context, reader io.Reader, size int64, etargetFile string) error {
+	if err := checkTargetFile(etargetFile); err != nil {
 		return err
 	}
-	defer func() { _ = client.Close() }()
-	defer func() { _ = session.Close() }()
 
-	w, err := session.StdinPipe()
+	c, err := ssh_conf.DialContext(ctx)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = c.Close() }()
 
-	copyF := func() error {
-		_, err := fmt.Fprintln(w, "C0644", size, targetFile)
-		if err != nil {
-			return err
-		}
-
-		if size > 0 {
-			_, err = io.Copy(w, reader)
-			if err != nil {
-				return err
-			}
-		}
-
-		_, err = fmt.Fprint(w, "\x00")
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}
-
-	copyErrC := make(chan error, 1)
-	go func() {
-		defer func() { _ = w.Close() }()
-		copyErrC <- copyF()
-	}()
+	return c.writeFile(ctx, reader, size, etargetFile)
+}
 
-	err = session.Run("scp -tr " + shellQuote(etargetFile))
-	if err != nil {
-		return err
+// checkTargetFile rejects characters that would either inject extra SCP
+// control records (\n, \r) or terminate the filename field early (\x00). The
+// remote-side scp command is invoked through the user's shell, so the target
+// is single-quoted by writeFile to neutralise shell metacharacters.
+func checkTargetFile(etargetFile string) error {
+	targetFile := filepath.Base(etargetFile)
+	if strings.ContainsAny(etargetFile, "\x00\n\r") || strings.ContainsAny(targetFile, "\x00\n\r") {
+		return ErrInvalidTargetFile
 	}
-
-	err = <-copyErrC
-	return err
+	return nil
 }
 
 // shellQuote returns s wrapped in POSIX single quotes so it can be passed as
@@ -548,24 +831,17 @@ func shellQuote(s string) string {
 
 // Scp uploads sourceFile to remote machine like native scp console app.
 func (ssh_conf *MakeConfig) Scp(sourceFile string, etargetFile string) error {
-	session, client, err := ssh_conf.Connect()
+	return ssh_conf.ScpContext(context.Background(), sourceFile, etargetFile)
+}
+
+// ScpContext is like Scp but aborts the connection and the transfer as soon
+// as ctx is done.
+func (ssh_conf *MakeConfig) ScpContext(ctx context.Context, sourceFile string, etargetFile string) error {
+	c, err := ssh_conf.DialContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = client.Close() }()
-	defer func() { _ = session.Close() }()
+	defer func() { _ = c.Close() }()
 
-	src, srcErr := os.Open(sourceFile)
-
-	if srcErr != nil {
-		return srcErr
-	}
-	defer func() { _ = src.Close() }()
-
-	srcStat, statErr := src.Stat()
-
-	if statErr != nil {
-		return statErr
-	}
-	return ssh_conf.WriteFile(src, srcStat.Size(), etargetFile)
+	return c.ScpContext(ctx, sourceFile, etargetFile)
 }
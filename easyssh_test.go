@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"net"
 	"os"
 	"os/user"
 	"path"
@@ -372,6 +373,43 @@ func TestProxyClientSSHCommand(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestProxyJumpChain(t *testing.T) {
+	hop := DefaultConfig{
+		User:    "drone-scp",
+		Server:  "localhost",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	ssh := &MakeConfig{
+		User:      "drone-scp",
+		Server:    "localhost",
+		Port:      "22",
+		KeyPath:   "./tests/.ssh/id_rsa",
+		Proxy:     hop,
+		ProxyJump: []DefaultConfig{hop, hop},
+	}
+
+	outStr, errStr, isTimeout, err := ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.Equal(t, "", errStr)
+	assert.True(t, isTimeout)
+	assert.NoError(t, err)
+
+	// the last hop can't authenticate
+	ssh.ProxyJump = []DefaultConfig{hop, {
+		User:     "drone-scp",
+		Server:   "localhost",
+		Port:     "22",
+		Password: "123456",
+	}}
+
+	session, client, err := ssh.Connect()
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.Error(t, err)
+}
+
 func TestSCPCommandWithPassword(t *testing.T) {
 	ssh := &MakeConfig{
 		Server:   "localhost",
@@ -667,6 +705,139 @@ func TestProxyGoroutineLeak(t *testing.T) {
 		"Goroutine leak detected: initial=%d, final=%d", initialGoroutines, finalGoroutines)
 }
 
+// TestConnectContextHandshakeCancel dials a listener that never answers the
+// SSH handshake and checks that the context aborts the connection attempt.
+func TestConnectContextHandshakeCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	ssh := &MakeConfig{
+		Server:   "127.0.0.1",
+		User:     "drone-scp",
+		Port:     port,
+		Password: "1234",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	session, client, err := ssh.ConnectContext(ctx)
+	elapsed := time.Since(start)
+
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, elapsed < 2*time.Second, "handshake should be aborted by the context, took %v", elapsed)
+}
+
+func TestConnectContextCanceled(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	session, client, err := ssh.ConnectContext(ctx)
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestConnectContextClosesOnCancel(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session, client, err := ssh.ConnectContext(ctx)
+	if !assert.NoError(t, err) {
+		cancel()
+		return
+	}
+	assert.NoError(t, session.Run("true"))
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client is still open after the context was canceled")
+	}
+	_, err = client.NewSession()
+	assert.Error(t, err)
+}
+
+func TestRunContext(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	outStr, errStr, isTimeout, err := ssh.RunContext(context.Background(), "whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.Equal(t, "", errStr)
+	assert.True(t, isTimeout)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	outStr, errStr, isTimeout, err = ssh.RunContext(ctx, "whoami; sleep 5")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.Equal(t, "", errStr)
+	assert.False(t, isTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// blockingReader never returns, like a stalled upload source.
+type blockingReader struct{}
+
+func (blockingReader) Read(p []byte) (int, error) {
+	select {}
+}
+
+func TestWriteFileContextCancel(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	err := ssh.WriteFileContext(ctx, blockingReader{}, 10, "blocked.txt")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 // TestShellQuote pins the contract of the helper used to defend the SCP code
 // path against remote command injection. If any of these cases regress, an
 // attacker-controlled target path could execute arbitrary commands on the
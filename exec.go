@@ -0,0 +1,486 @@
+package easyssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrCommandTimeout is returned by Exec when the command did not finish
+// before its timeout or context. It is joined with the context error, so
+// errors.Is(err, context.DeadlineExceeded) holds as well.
+var ErrCommandTimeout = errors.New("easyssh: command timed out")
+
+// Result is the outcome of a command run with Exec.
+type Result struct {
+	// Host is the address of the server as host:port and User the remote
+	// user the command ran as.
+	Host string
+	User string
+
+	Command string
+	Stdout  string
+	Stderr  string
+
+	// ExitCode is the exit status of the command, 128 plus the signal number
+	// when it was killed by a signal as in a shell, or -1 when it timed out
+	// or did not report a status.
+	ExitCode int
+	// Signal is the name of the signal that killed the command, without the
+	// SIG prefix (ex. TERM), or empty.
+	Signal string
+	// TimedOut is true when the command or the connection timed out.
+	TimedOut bool
+
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// ExitError is returned by Exec when the command exits with a non-zero status
+// or is killed by a signal.
+type ExitError struct {
+	Host     string
+	ExitCode int
+	Signal   string
+	Err      *ssh.ExitError
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("easyssh: command on %s killed by signal %s", e.Host, e.Signal)
+	}
+	return fmt.Sprintf("easyssh: command on %s exited with status %d", e.Host, e.ExitCode)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitError returns err as an *ExitError when it is an *ssh.ExitError of a
+// command run on host, and unchanged otherwise.
+func exitError(host string, err error) error {
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	return &ExitError{Host: host, ExitCode: exitErr.ExitStatus(), Signal: exitErr.Signal(), Err: exitErr}
+}
+
+// Exec runs command on the remote machine and returns its Result. Unlike
+// Run, the output is returned as written by the command.
+//
+// The Result is never nil. The error is an *ExitError when the command
+// failed, wraps ErrCommandTimeout when it did not finish within the timeout,
+// and is the connection error when the command could not be started.
+func (ssh_conf *MakeConfig) Exec(command string, timeout ...time.Duration) (*Result, error) {
+	startedAt := time.Now()
+	c, err := ssh_conf.Dial()
+	if err != nil {
+		return ssh_conf.dialResult(command, startedAt, err), err
+	}
+	defer func() { _ = c.release() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout(timeout))
+	defer cancel()
+	return c.exec(ctx, command, c.options(nil), startedAt)
+}
+
+// ExecContext is like Exec but the connection and the command are bound to
+// ctx instead of a timeout, and configured by opts such as WithStdin.
+func (ssh_conf *MakeConfig) ExecContext(ctx context.Context, command string, opts ...ExecOption) (*Result, error) {
+	startedAt := time.Now()
+	c, err := ssh_conf.DialContext(ctx)
+	if err != nil {
+		return ssh_conf.dialResult(command, startedAt, err), err
+	}
+	defer func() { _ = c.release() }()
+
+	return c.exec(ctx, command, c.options(opts), startedAt)
+}
+
+// RunWithWriters runs command on the remote machine and copies its stdout
+// and stderr to the given writers byte for byte, which suits binary output
+// such as that of tar or pg_dump. A nil writer discards the output and
+// stdout and stderr may be the same writer. The errors are those of Exec.
+func (ssh_conf *MakeConfig) RunWithWriters(command string, stdout, stderr io.Writer, timeout ...time.Duration) error {
+	c, err := ssh_conf.Dial()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.release() }()
+
+	return c.RunWithWriters(command, stdout, stderr, timeout...)
+}
+
+// RunWithWritersContext is like RunWithWriters but the connection and the
+// command are bound to ctx instead of a timeout, and configured by opts such
+// as WithStdin.
+func (ssh_conf *MakeConfig) RunWithWritersContext(ctx context.Context, command string, stdout, stderr io.Writer, opts ...ExecOption) error {
+	c, err := ssh_conf.DialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.release() }()
+
+	return c.RunWithWritersContext(ctx, command, stdout, stderr, opts...)
+}
+
+// dialResult is the Result of a command whose connection failed.
+func (ssh_conf *MakeConfig) dialResult(command string, startedAt time.Time, err error) *Result {
+	res := ssh_conf.newResult(command, startedAt)
+	res.TimedOut = errors.Is(err, ErrProxyDialTimeout) || errors.Is(err, context.DeadlineExceeded)
+	res.Duration = time.Since(startedAt)
+	return res
+}
+
+func (ssh_conf *MakeConfig) newResult(command string, startedAt time.Time) *Result {
+	return &Result{
+		Host:      net.JoinHostPort(ssh_conf.Server, ssh_conf.Port),
+		User:      ssh_conf.User,
+		Command:   command,
+		ExitCode:  -1,
+		StartedAt: startedAt,
+	}
+}
+
+// Exec runs command in a new session and returns its Result. See
+// MakeConfig.Exec.
+func (c *Client) Exec(command string, timeout ...time.Duration) (*Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout(timeout))
+	defer cancel()
+	return c.exec(ctx, command, c.options(nil), time.Now())
+}
+
+// ExecContext is like Exec but the command is bound to ctx instead of a
+// timeout, and configured by opts such as WithStdin. The Client stays open
+// when ctx is done.
+func (c *Client) ExecContext(ctx context.Context, command string, opts ...ExecOption) (*Result, error) {
+	return c.exec(ctx, command, c.options(opts), time.Now())
+}
+
+// RunWithWriters runs command in a new session and copies its stdout and
+// stderr to the given writers. See MakeConfig.RunWithWriters.
+func (c *Client) RunWithWriters(command string, stdout, stderr io.Writer, timeout ...time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout(timeout))
+	defer cancel()
+	_, err := c.execWriters(ctx, command, c.options(nil), time.Now(), stdout, stderr)
+	return err
+}
+
+// RunWithWritersContext is like RunWithWriters but the command is bound to
+// ctx instead of a timeout, and configured by opts such as WithStdin.
+func (c *Client) RunWithWritersContext(ctx context.Context, command string, stdout, stderr io.Writer, opts ...ExecOption) error {
+	_, err := c.execWriters(ctx, command, c.options(opts), time.Now(), stdout, stderr)
+	return err
+}
+
+func (c *Client) exec(ctx context.Context, command string, o execOptions, startedAt time.Time) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	res, err := c.execWriters(ctx, command, o, startedAt, &stdout, &stderr)
+	res.Stdout, res.Stderr = stdout.String(), stderr.String()
+	return res, err
+}
+
+// execWriters runs command with the options o, copying its output to stdout
+// and stderr, and returns its Result without the output.
+func (c *Client) execWriters(ctx context.Context, command string, o execOptions, startedAt time.Time, stdout, stderr io.Writer) (*Result, error) {
+	res := c.config.newResult(command, startedAt)
+	err := c.run(ctx, command, o, stdout, stderr)
+	res.Duration = time.Since(startedAt)
+
+	err = exitError(res.Host, err)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		res.ExitCode = 0
+	case errors.Is(err, ErrCommandTimeout):
+		res.TimedOut = true
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode
+		res.Signal = exitErr.Signal
+	}
+	return res, err
+}
+
+// run runs command in a new session with the options o, copying its output
+// to stdout and stderr, until it finishes or ctx is done.
+func (c *Client) run(ctx context.Context, command string, o execOptions, stdout, stderr io.Writer) error {
+	session, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	output := &sessionOutput{stdout: stdout, stderr: stderr}
+	session.Stdout = writerFunc(output.writeStdout)
+	session.Stderr = writerFunc(output.writeStderr)
+	p, err := c.start(session, command, o)
+	if err != nil {
+		_ = session.Close()
+		return err
+	}
+
+	select {
+	case <-p.exited:
+		_ = session.Close()
+		if err := p.stdinErr(); err != nil {
+			return err
+		}
+		return p.err
+	case <-ctx.Done():
+		// the session is closed once the command is stopped
+		output.detach()
+		p.stop()
+		return fmt.Errorf("%w: %w", ErrCommandTimeout, ctx.Err())
+	}
+}
+
+// start starts command in session with the input, environment, working
+// directory and sudo of o, and the process group of the configuration.
+func (c *Client) start(session *ssh.Session, command string, o execOptions) (*remoteProcess, error) {
+	if c.config.ProcessGroup && o.sudo != nil {
+		// Validate rejects MakeConfig.Sudo, this is WithSudo
+		return nil, &ValidationError{Errors: []*FieldError{{Field: "ProcessGroup", Value: "WithSudo", Err: ErrConflictingOption}}}
+	}
+	for _, name := range envNames(o.env) {
+		if !validEnvName(name) {
+			return nil, fmt.Errorf("easyssh: %w %q", ErrInvalidEnv, name)
+		}
+	}
+
+	var rejected []string
+	if o.sudo != nil {
+		// sudo resets the environment, the command sets the variables itself
+		rejected = envNames(o.env)
+	} else {
+		for _, name := range envNames(o.env) {
+			if err := session.Setenv(name, o.env[name]); err != nil {
+				rejected = append(rejected, name)
+			}
+		}
+	}
+
+	prefix, err := commandPrefix(o.workingDir, rejected, o.env)
+	if err != nil {
+		return nil, err
+	}
+	command = prefix + command
+
+	stdin := o.stdin
+	p := &remoteProcess{client: c, session: session}
+	if o.sudo != nil {
+		if p.sudo, stdin, err = newSudoSession(o.sudo, stdin); err != nil {
+			return nil, err
+		}
+		command = p.sudo.command(command, o.sudo.User)
+		session.Stdout = p.sudo.output(session.Stdout, true)
+		session.Stderr = p.sudo.output(session.Stderr, false)
+	}
+	if c.config.ProcessGroup {
+		if command, p.pgidFile, err = processGroupCommand(command); err != nil {
+			return nil, err
+		}
+	}
+	if p.stdinErr, err = copyStdin(session, stdin); err != nil {
+		return nil, err
+	}
+	if err := session.Start(command); err != nil {
+		return nil, err
+	}
+	p.wait()
+	return p, nil
+}
+
+// ExecOption configures a single command run by the Context methods, such
+// as ExecContext and StreamContext. Unlike the fields of MakeConfig, which
+// are the defaults of every command, they can differ between the commands
+// run at the same time on a Client.
+type ExecOption func(*execOptions)
+
+type execOptions struct {
+	stdin      io.Reader
+	env        map[string]string
+	workingDir string
+	sudo       *SudoOptions
+}
+
+// WithStdin copies stdin to the standard input of the command, for example
+// a SQL dump fed to psql. The input of the command is closed once stdin
+// returns io.EOF. An error reading stdin is returned, or sent on the error
+// channel of StreamContext, instead of the result of the command.
+func WithStdin(stdin io.Reader) ExecOption {
+	return func(o *execOptions) {
+		o.stdin = stdin
+	}
+}
+
+// WithEnv sets environment variables for the command, in addition to
+// MakeConfig.Env and replacing those of the same name. They are sent the
+// same way, see MakeConfig.Env.
+func WithEnv(env map[string]string) ExecOption {
+	return func(o *execOptions) {
+		merged := make(map[string]string, len(o.env)+len(env))
+		maps.Copy(merged, o.env)
+		maps.Copy(merged, env)
+		o.env = merged
+	}
+}
+
+// WithWorkingDir runs the command in dir instead of MakeConfig.WorkingDir,
+// or in the home directory of the user when dir is empty.
+func WithWorkingDir(dir string) ExecOption {
+	return func(o *execOptions) {
+		o.workingDir = dir
+	}
+}
+
+// WithSudo runs the command with sudo as described by opts instead of
+// MakeConfig.Sudo, or without sudo when opts is nil. See MakeConfig.Sudo.
+// It can not be combined with MakeConfig.ProcessGroup.
+func WithSudo(opts *SudoOptions) ExecOption {
+	return func(o *execOptions) {
+		o.sudo = opts
+	}
+}
+
+// options applies opts to the defaults from the configuration.
+func (c *Client) options(opts []ExecOption) execOptions {
+	o := execOptions{env: c.config.Env, workingDir: c.config.WorkingDir, sudo: c.config.Sudo}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// commandPrefix returns the shell commands run before a command to change to
+// dir and export the env variables listed in names.
+func commandPrefix(dir string, names []string, env map[string]string) (string, error) {
+	var b strings.Builder
+	if dir != "" {
+		b.WriteString("cd " + shellQuote(dir) + " || exit 1; ")
+	}
+	if len(names) > 0 {
+		b.WriteString("export")
+		for _, name := range names {
+			if !validEnvName(name) {
+				return "", fmt.Errorf("easyssh: %w %q", ErrInvalidEnv, name)
+			}
+			b.WriteString(" " + name + "=" + shellQuote(env[name]))
+		}
+		b.WriteString("; ")
+	}
+	return b.String(), nil
+}
+
+// envNames returns the names of env in order.
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validEnvName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// copyStdin copies stdin to the standard input of session, closing it once
+// stdin is exhausted. Unlike ssh.Session.Stdin, the copy does not hold up
+// session.Wait when the command exits without reading all of its input. The
+// returned function reports an error reading stdin.
+func copyStdin(session *ssh.Session, stdin io.Reader) (func() error, error) {
+	if stdin == nil {
+		return func() error { return nil }, nil
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		// Write errors only mean that the command stopped reading its
+		// input and are not reported.
+		_, _ = io.Copy(w, readerFunc(func(p []byte) (int, error) {
+			n, err := stdin.Read(p)
+			if err != nil && err != io.EOF {
+				// Reported before closing stdin, which may end the
+				// command, so that it is seen once the command is done.
+				readErr <- fmt.Errorf("easyssh: read stdin: %w", err)
+			}
+			return n, err
+		}))
+		_ = w.Close()
+	}()
+
+	return func() error {
+		select {
+		case err := <-readErr:
+			return err
+		default:
+			return nil
+		}
+	}, nil
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// sessionOutput serializes the writes of a session to stdout and stderr and
+// drops them once detached, so the writers are no longer used after run
+// returns even if the session is still draining.
+type sessionOutput struct {
+	mu       sync.Mutex
+	detached bool
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+func (o *sessionOutput) writeStdout(p []byte) (int, error) { return o.write(o.stdout, p) }
+func (o *sessionOutput) writeStderr(p []byte) (int, error) { return o.write(o.stderr, p) }
+
+func (o *sessionOutput) write(w io.Writer, p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.detached {
+		return 0, io.ErrClosedPipe
+	}
+	return w.Write(p)
+}
+
+func (o *sessionOutput) detach() {
+	o.mu.Lock()
+	o.detached = true
+	o.mu.Unlock()
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,312 @@
+package easyssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExec(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	res, err := sshConf.Exec("printf 'a\\n\\nb'; echo oops >&2")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:22", res.Host)
+	assert.Equal(t, "drone-scp", res.User)
+	assert.Equal(t, "a\n\nb", res.Stdout)
+	assert.Equal(t, "oops\n", res.Stderr)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Empty(t, res.Signal)
+	assert.False(t, res.TimedOut)
+	assert.False(t, res.StartedAt.IsZero())
+	assert.Greater(t, res.Duration, time.Duration(0))
+
+	res, err = sshConf.Exec("echo 1; exit 3")
+	var exitErr *ExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, 3, exitErr.ExitCode)
+		assert.Equal(t, "easyssh: command on localhost:22 exited with status 3", exitErr.Error())
+	}
+	var sshExitErr *ssh.ExitError
+	assert.True(t, errors.As(err, &sshExitErr))
+	assert.Equal(t, "1\n", res.Stdout)
+	assert.Equal(t, 3, res.ExitCode)
+
+	res, err = sshConf.Exec("kill -KILL $$")
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, "KILL", exitErr.Signal)
+	}
+	assert.Equal(t, 128+9, res.ExitCode)
+	assert.Equal(t, "KILL", res.Signal)
+
+	res, err = sshConf.Exec("echo 1; sleep 5", 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, res.TimedOut)
+	assert.Equal(t, -1, res.ExitCode)
+	assert.Equal(t, "1\n", res.Stdout)
+	assert.Less(t, res.Duration, 5*time.Second)
+}
+
+func TestClientExec(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	client, err := sshConf.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	res, err := client.ExecContext(ctx, "sleep 5")
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.True(t, res.TimedOut)
+
+	// the client is still usable
+	res, err = client.Exec("whoami")
+	assert.NoError(t, err)
+	assert.Equal(t, "drone-scp\n", res.Stdout)
+}
+
+func TestExecDialError(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := sshConf.ExecContext(ctx, "whoami")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "localhost:22", res.Host)
+	assert.Equal(t, "whoami", res.Command)
+	assert.Equal(t, -1, res.ExitCode)
+	assert.False(t, res.TimedOut)
+}
+
+func TestRunWithWriters(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	// NUL, high bytes, CRLF, empty lines and no trailing newline survive
+	var stdout, stderr bytes.Buffer
+	err := sshConf.RunWithWriters(`printf 'a\000b\377\r\n\n\nc'; printf 'e\n\n' >&2`, &stdout, &stderr)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a\x00b\xff\r\n\n\nc"), stdout.Bytes())
+	assert.Equal(t, "e\n\n", stderr.String())
+
+	stdout.Reset()
+	err = sshConf.RunWithWriters("head -c 1048576 /dev/urandom", &stdout, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1048576, stdout.Len())
+
+	// the same writer for both streams
+	var combined bytes.Buffer
+	err = sshConf.RunWithWriters("echo out; echo err >&2; exit 2", &combined, &combined)
+	var exitErr *ExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, 2, exitErr.ExitCode)
+	}
+	assert.Contains(t, combined.String(), "out\n")
+	assert.Contains(t, combined.String(), "err\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = sshConf.RunWithWritersContext(ctx, "sleep 5", nil, nil)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+}
+
+func TestStdin(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+	ctx := context.Background()
+
+	outStr, _, _, err := sshConf.RunContext(ctx, "tr a-z A-Z", WithStdin(strings.NewReader("hello\nworld\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\nWORLD\n", outStr)
+
+	data := bytes.Repeat([]byte{0, 1, 2, 0xff}, 256*1024)
+	res, err := sshConf.ExecContext(ctx, "wc -c", WithStdin(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "1048576", strings.TrimSpace(res.Stdout))
+
+	res, err = sshConf.ExecContext(ctx, "cat", WithStdin(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, []byte(res.Stdout))
+
+	var stdout bytes.Buffer
+	err = sshConf.RunWithWritersContext(ctx, "cat", &stdout, nil, WithStdin(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, stdout.Bytes())
+
+	stdoutChan, _, doneChan, errChan, err := sshConf.StreamContext(ctx, "cat", WithStdin(strings.NewReader("line\n")))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "line", <-stdoutChan)
+	assert.NoError(t, <-errChan)
+	assert.True(t, <-doneChan)
+
+	// a command that does not read its input does not wait for it
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err = sshConf.ExecContext(timeoutCtx, "echo done", WithStdin(r))
+	assert.NoError(t, err)
+	assert.Equal(t, "done\n", res.Stdout)
+
+	// read errors are reported
+	_, err = sshConf.ExecContext(ctx, "cat", WithStdin(io.MultiReader(strings.NewReader("partial\n"), iotest.ErrReader(errors.New("disk failure")))))
+	assert.ErrorContains(t, err, "easyssh: read stdin: disk failure")
+
+	_, _, _, err = sshConf.RunContext(ctx, "cat", WithStdin(iotest.ErrReader(errors.New("disk failure"))))
+	assert.ErrorContains(t, err, "disk failure")
+
+	// each command of a shared Client has its own input
+	c, err := sshConf.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input := strings.Repeat(fmt.Sprintf("%d\n", i), 1000)
+			res, err := c.ExecContext(ctx, "cat", WithStdin(strings.NewReader(input)))
+			assert.NoError(t, err)
+			assert.Equal(t, input, res.Stdout)
+		}()
+	}
+	wg.Wait()
+
+	res, err = c.Exec("cat")
+	assert.NoError(t, err)
+	assert.Empty(t, res.Stdout)
+}
+
+func TestCommandPrefix(t *testing.T) {
+	env := map[string]string{"A": "1", "QUOTED": "it's $HOME"}
+	prefix, err := commandPrefix("/srv/my app", []string{"A", "QUOTED"}, env)
+	assert.NoError(t, err)
+	assert.Equal(t, `cd '/srv/my app' || exit 1; export A='1' QUOTED='it'\''s $HOME'; `, prefix)
+
+	prefix, err = commandPrefix("", nil, env)
+	assert.NoError(t, err)
+	assert.Empty(t, prefix)
+
+	_, err = commandPrefix("", []string{"A B"}, map[string]string{"A B": "1"})
+	assert.ErrorIs(t, err, ErrInvalidEnv)
+
+	assert.True(t, validEnvName("_PATH2"))
+	assert.False(t, validEnvName("2PATH"))
+	assert.False(t, validEnvName("A-B"))
+	assert.False(t, validEnvName(""))
+}
+
+func TestEnvAndWorkingDir(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:     "localhost",
+		User:       "drone-scp",
+		Port:       "22",
+		KeyPath:    "./tests/.ssh/id_rsa",
+		Env:        map[string]string{"GREETING": "hello 'world'", "LC_EASYSSH": "$HOME"},
+		WorkingDir: "/tmp",
+	}
+
+	outStr, _, _, err := sshConf.Run(`pwd; echo "$GREETING"; echo "$LC_EASYSSH"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp\nhello 'world'\n$HOME\n", outStr)
+
+	sshConf.WorkingDir = "/does/not/exist"
+	res, err := sshConf.Exec("echo ran")
+	assert.Error(t, err)
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Empty(t, res.Stdout)
+
+	sshConf.WorkingDir = ""
+	sshConf.Env = map[string]string{"NOT-VALID": "1"}
+	_, err = sshConf.Exec("true")
+	assert.ErrorIs(t, err, ErrInvalidEnv)
+
+	// the commands of a shared Client have their own environment and
+	// directory, the configuration is left alone
+	sshConf.Env = map[string]string{"GREETING": "hello"}
+	c, err := sshConf.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	ctx := context.Background()
+	res, err = c.ExecContext(ctx, `pwd; echo "$GREETING $NAME"`, WithEnv(map[string]string{"NAME": "world"}), WithWorkingDir("/tmp"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp\nhello world\n", res.Stdout)
+
+	res, err = c.ExecContext(ctx, `echo "$GREETING"`, WithEnv(map[string]string{"GREETING": "bye"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "bye\n", res.Stdout)
+
+	res, err = c.Exec(`echo "$GREETING $NAME"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello \n", res.Stdout)
+
+	_, err = c.ExecContext(ctx, "true", WithEnv(map[string]string{"NOT-VALID": "1"}))
+	assert.ErrorIs(t, err, ErrInvalidEnv)
+}
+
+func TestExecOptions(t *testing.T) {
+	config := &MakeConfig{
+		Env:        map[string]string{"A": "1", "B": "2"},
+		WorkingDir: "/srv",
+		Sudo:       &SudoOptions{User: "postgres"},
+	}
+	c := &Client{config: config}
+
+	o := c.options(nil)
+	assert.Equal(t, config.Env, o.env)
+	assert.Equal(t, "/srv", o.workingDir)
+	assert.Equal(t, config.Sudo, o.sudo)
+
+	assert.Nil(t, c.options([]ExecOption{WithSudo(nil)}).sudo)
+	other := &SudoOptions{User: "www-data"}
+	assert.Equal(t, other, c.options([]ExecOption{WithSudo(other)}).sudo)
+
+	o = c.options([]ExecOption{WithEnv(map[string]string{"B": "3", "C": "4"}), WithWorkingDir("")})
+	assert.Equal(t, map[string]string{"A": "1", "B": "3", "C": "4"}, o.env)
+	assert.Empty(t, o.workingDir)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, config.Env)
+}
@@ -0,0 +1,325 @@
+package easyssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrExpectTimeout is returned by Expecter.Expect when the pattern does not
+// appear in the output within the timeout.
+var ErrExpectTimeout = errors.New("easyssh: expect timeout")
+
+type (
+	// Expecter drives an interactive command, such as an installer asking
+	// questions, by waiting for patterns in its output and answering them.
+	// The command runs with a pseudo-terminal, see MakeConfig.Pty. Its
+	// standard error is merged with its output by the terminal.
+	//
+	//	e, err := ssh.Expect("./install.sh")
+	//	defer e.Close()
+	//	e.Respond(`--More--`, " ")
+	//	if _, err := e.Expect(`Continue\? \[y/N\]`, 10*time.Second); err != nil {
+	//		log.Fatal(err, e.Transcript())
+	//	}
+	//	e.SendLine("y")
+	//	err = e.Wait(time.Minute)
+	Expecter struct {
+		client     *Client
+		ownsClient bool
+		session    *ssh.Session
+		process    *remoteProcess
+		stdin      io.WriteCloser
+
+		mu         sync.Mutex
+		unread     []byte
+		transcript bytes.Buffer
+		rules      []expectRule
+		update     chan struct{}
+		// stopping is set once Wait has timed out and the command is being
+		// stopped in the background.
+		stopping bool
+		// waited is set once Wait has returned waitErr, which later calls
+		// return as well.
+		waited  bool
+		waitErr error
+	}
+
+	expectRule struct {
+		pattern  *regexp.Regexp
+		callback func(match []string) string
+	}
+)
+
+// Expect runs command on the remote machine and returns an Expecter to
+// interact with it. The Expecter must be closed.
+func (ssh_conf *MakeConfig) Expect(command string) (*Expecter, error) {
+	c, err := ssh_conf.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := c.Expect(command)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	e.ownsClient = true
+	return e, nil
+}
+
+// Expect runs command in a new session and returns an Expecter to interact
+// with it. See MakeConfig.Expect.
+func (c *Client) Expect(command string) (*Expecter, error) {
+	if command == "" {
+		return nil, errors.New("easyssh: expect needs a command")
+	}
+	if c.config.ProcessGroup {
+		// the command would lose its terminal, see MakeConfig.ProcessGroup
+		return nil, &ValidationError{Errors: []*FieldError{{Field: "ProcessGroup", Value: "Expect", Err: ErrConflictingOption}}}
+	}
+
+	pty := c.config.pty()
+	if pty == nil {
+		pty = (&MakeConfig{RequestPty: true}).pty()
+	}
+	session, err := c.newSession(pty)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Expecter{client: c, session: session, update: make(chan struct{}, 1)}
+	session.Stdout = writerFunc(e.receive)
+	session.Stderr = writerFunc(e.receive)
+	stdin, w := io.Pipe()
+	e.stdin = w
+	o := c.options(nil)
+	o.stdin = stdin
+	if e.process, err = c.start(session, command, o); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	go func() {
+		// Send fails rather than blocks once the command has exited
+		<-e.process.exited
+		_ = stdin.Close()
+	}()
+	return e, nil
+}
+
+// receive collects the output of the command.
+func (e *Expecter) receive(p []byte) (int, error) {
+	e.mu.Lock()
+	e.unread = append(e.unread, p...)
+	e.transcript.Write(p)
+	e.mu.Unlock()
+
+	select {
+	case e.update <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// Respond registers a rule answering every output matching the regular
+// expression pattern with response, while Expect or Wait run. It suits
+// prompts that appear any number of times, such as pagers.
+func (e *Expecter) Respond(pattern, response string) error {
+	return e.Handle(pattern, func([]string) string { return response })
+}
+
+// Handle registers a rule like Respond whose response is returned by
+// callback, called with the match and its submatches.
+func (e *Expecter) Handle(pattern string, callback func(match []string) string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = append(e.rules, expectRule{pattern: re, callback: callback})
+	e.mu.Unlock()
+	return nil
+}
+
+// Expect waits until the output matches the regular expression pattern and
+// returns the match and its submatches. The output up to the end of the
+// match is consumed, so that the next call only sees what follows. Rules
+// registered with Respond and Handle are applied meanwhile.
+//
+// It fails with ErrExpectTimeout when there is no match within timeout, and
+// with io.EOF when the command exits first.
+func (e *Expecter) Expect(pattern string, timeout time.Duration) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		match, err := e.next(re)
+		if match != nil || err != nil {
+			return match, err
+		}
+
+		select {
+		case <-e.update:
+		case <-e.process.exited:
+			// the output is complete once the command has exited
+			if match, err := e.next(re); match != nil || err != nil {
+				return match, err
+			}
+			return nil, fmt.Errorf("easyssh: expect %q: %w", pattern, io.EOF)
+		case <-timer.C:
+			return nil, fmt.Errorf("%w: %q", ErrExpectTimeout, pattern)
+		}
+	}
+}
+
+// next applies the rules to the unread output and returns the match of re,
+// or nil when it does not match yet.
+func (e *Expecter) next(re *regexp.Regexp) ([]string, error) {
+	for {
+		e.mu.Lock()
+		// the earliest match wins
+		var (
+			loc  []int
+			rule *expectRule
+		)
+		if re != nil {
+			loc = re.FindSubmatchIndex(e.unread)
+		}
+		for i := range e.rules {
+			l := e.rules[i].pattern.FindSubmatchIndex(e.unread)
+			// empty matches are ignored, they would be answered forever
+			if l != nil && l[1] > l[0] && (loc == nil || l[0] < loc[0]) {
+				loc, rule = l, &e.rules[i]
+			}
+		}
+		if loc == nil {
+			e.mu.Unlock()
+			return nil, nil
+		}
+
+		match := submatches(e.unread, loc)
+		e.unread = e.unread[loc[1]:]
+		e.mu.Unlock()
+
+		if rule == nil {
+			return match, nil
+		}
+		if err := e.Send(rule.callback(match)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func submatches(b []byte, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = string(b[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	return match
+}
+
+// Send writes s to the standard input of the command.
+func (e *Expecter) Send(s string) error {
+	e.mu.Lock()
+	e.transcript.WriteString(s)
+	e.mu.Unlock()
+
+	_, err := io.WriteString(e.stdin, s)
+	return err
+}
+
+// SendLine writes s followed by a newline to the standard input of the
+// command.
+func (e *Expecter) SendLine(s string) error {
+	return e.Send(s + "\n")
+}
+
+// Wait waits for the command to exit, applying the rules registered with
+// Respond and Handle meanwhile. A non-zero exit status is returned as an
+// *ExitError. When the command does not exit within timeout it is stopped,
+// see MakeConfig.KillGracePeriod, and the error wraps ErrCommandTimeout.
+// Once Wait has returned, later calls return the same error right away.
+func (e *Expecter) Wait(timeout time.Duration) error {
+	e.mu.Lock()
+	waited, err := e.waited, e.waitErr
+	e.mu.Unlock()
+	if waited {
+		return err
+	}
+
+	err = e.wait(timeout)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.waited {
+		e.waited, e.waitErr = true, err
+	}
+	return e.waitErr
+}
+
+func (e *Expecter) wait(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if _, err := e.next(nil); err != nil {
+			return err
+		}
+
+		select {
+		case <-e.update:
+		case <-e.process.exited:
+			return exitError(net.JoinHostPort(e.client.config.Server, e.client.config.Port), e.process.err)
+		case <-timer.C:
+			e.mu.Lock()
+			stopping := e.stopping
+			e.stopping = true
+			e.mu.Unlock()
+			if !stopping {
+				e.process.stop()
+			}
+			return fmt.Errorf("%w: %w", ErrCommandTimeout, context.DeadlineExceeded)
+		}
+	}
+}
+
+// Transcript returns the output of the command and the input sent to it so
+// far, in the order they occurred.
+func (e *Expecter) Transcript() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.transcript.String()
+}
+
+// Close closes the session of the command and, for an Expecter returned by
+// MakeConfig.Expect, the connection.
+func (e *Expecter) Close() error {
+	_ = e.stdin.Close()
+
+	e.mu.Lock()
+	stopping := e.stopping
+	e.mu.Unlock()
+	var err error
+	if !stopping {
+		// otherwise it is closed once the command is stopped
+		err = e.session.Close()
+	}
+
+	if e.ownsClient {
+		return e.client.release()
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package easyssh
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const expectScript = `printf 'Continue? [y/N] '; read a; echo "got $a"
+for i in 1 2 3; do printf 'license page %s --More--' $i; read b; done
+printf 'Name: '; read n; echo "hello $n"; exit 2`
+
+func TestExpect(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	e, err := sshConf.Expect(expectScript)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = e.Close() }()
+
+	var pages []string
+	assert.NoError(t, e.Handle(`page (\d) --More--`, func(match []string) string {
+		pages = append(pages, match[1])
+		return "\n"
+	}))
+
+	_, err = e.Expect(`Continue\? \[y/N\] `, 5*time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, e.SendLine("y"))
+
+	match, err := e.Expect(`got (\w+)`, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"got y", "y"}, match)
+
+	_, err = e.Expect(`Name: `, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, pages)
+
+	_, err = e.Expect(`never printed`, 200*time.Millisecond)
+	assert.ErrorIs(t, err, ErrExpectTimeout)
+
+	assert.NoError(t, e.SendLine("drone"))
+	err = e.Wait(5 * time.Second)
+	var exitErr *ExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, 2, exitErr.ExitCode)
+	}
+
+	// the result is kept for later calls
+	start := time.Now()
+	assert.Equal(t, err, e.Wait(5*time.Second))
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = e.Expect(`more`, time.Second)
+	assert.ErrorIs(t, err, io.EOF)
+
+	transcript := e.Transcript()
+	assert.True(t, strings.HasPrefix(transcript, "Continue? [y/N] y\n"))
+	assert.Contains(t, transcript, "hello drone")
+
+	_, err = e.Expect(`(`, time.Second)
+	assert.Error(t, err)
+}
+
+func TestExpectWaitTimeout(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:          "localhost",
+		User:            "drone-scp",
+		Port:            "22",
+		KeyPath:         "./tests/.ssh/id_rsa",
+		KillGracePeriod: 200 * time.Millisecond,
+	}
+
+	e, err := sshConf.Expect("printf 'Password: '; sleep 30")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = e.Close() }()
+
+	assert.NoError(t, e.Respond(`Password: `, "secret\n"))
+	err = e.Wait(300 * time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.Contains(t, e.Transcript(), "Password: secret\n")
+
+	start := time.Now()
+	assert.ErrorIs(t, e.Wait(5*time.Second), ErrCommandTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+}
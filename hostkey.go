@@ -0,0 +1,268 @@
+package easyssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyChangedError is returned when the server presents a host key that
+// differs from the one recorded for it in a known_hosts file, which may mean
+// the connection is being intercepted.
+type HostKeyChangedError struct {
+	// Hostname is the address the host key was checked for.
+	Hostname string
+	// OldKeys holds the keys recorded for the host, with the file and
+	// line they were read from.
+	OldKeys []knownhosts.KnownKey
+	// NewKey is the key presented by the server.
+	NewKey ssh.PublicKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	known := make([]string, 0, len(e.OldKeys))
+	for i := range e.OldKeys {
+		known = append(known, e.OldKeys[i].String())
+	}
+	return fmt.Sprintf("ssh: host key for %s has changed to %s %s, known keys: %s",
+		e.Hostname, e.NewKey.Type(), ssh.FingerprintSHA256(e.NewKey), strings.Join(known, "; "))
+}
+
+// knownHostsMu serializes trust-on-first-use writes to known_hosts files.
+var knownHostsMu sync.Mutex
+
+// hostCertAlgorithms are the host key algorithms of certificates, asked for
+// first when host certificates can be verified.
+var hostCertAlgorithms = []string{
+	ssh.CertAlgoRSASHA512v01,
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoED25519v01,
+}
+
+// probeKey is never a real host key; checking it against a known_hosts
+// database reveals which keys are recorded for a host.
+var probeKey, _ = ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+// getHostKeyCallback returns the host key verification configured for config,
+// falling back to accepting any host key when none is configured. It also
+// returns the host key algorithms to ask the server for, nil meaning the
+// defaults.
+func getHostKeyCallback(config DefaultConfig) (ssh.HostKeyCallback, []string, error) {
+	var callbacks []ssh.HostKeyCallback
+	var algorithms []string
+	var certAuthorities bool
+
+	if config.Fingerprint != "" {
+		callbacks = append(callbacks, func(hostname string, remote net.Addr, publicKey ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(publicKey) != config.Fingerprint {
+				return ErrFingerprintMismatch
+			}
+			return nil
+		})
+	}
+
+	if len(config.KnownHosts) > 0 {
+		callback, known, err := knownHostsCallback(config.KnownHosts, config.TrustOnFirstUse)
+		if err != nil {
+			return nil, nil, err
+		}
+		callbacks = append(callbacks, callback)
+		if config.Server != "" {
+			algorithms = knownHostKeyAlgorithms(known, net.JoinHostPort(config.Server, config.Port))
+		}
+		if certAuthorities, err = hasCertAuthority(config.KnownHosts); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var callback ssh.HostKeyCallback
+	if len(callbacks) > 0 {
+		callback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, callback := range callbacks {
+				if err := callback(hostname, remote, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if len(config.HostCAKeys) > 0 {
+		authorities, err := parseHostCAKeys(config.HostCAKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Certificates are checked against the trusted authorities, plain
+		// host keys are only accepted by the verification configured above.
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+				for _, authority := range authorities {
+					if bytes.Equal(auth.Marshal(), authority.Marshal()) {
+						return true
+					}
+				}
+				return false
+			},
+			HostKeyFallback: callback,
+		}
+		callback = checker.CheckHostKey
+		certAuthorities = true
+	}
+
+	if callback == nil {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	if certAuthorities && algorithms != nil {
+		algorithms = append(append([]string{}, hostCertAlgorithms...), algorithms...)
+	}
+
+	return callback, algorithms, nil
+}
+
+// parseHostCAKeys parses public keys in authorized_keys format.
+func parseHostCAKeys(keys []string) ([]ssh.PublicKey, error) {
+	authorities := make([]ssh.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		authority, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("easyssh: parse host CA key: %w", err)
+		}
+		authorities = append(authorities, authority)
+	}
+	return authorities, nil
+}
+
+// hasCertAuthority reports whether any of the known_hosts files holds a
+// @cert-authority line.
+func hasCertAuthority(files []string) (bool, error) {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return false, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		found := false
+		for scanner.Scan() {
+			if bytes.HasPrefix(bytes.TrimSpace(scanner.Bytes()), []byte("@cert-authority")) {
+				found = true
+				break
+			}
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// knownHostsCallback verifies host keys against the given known_hosts files.
+// Files that do not exist are treated as empty, as OpenSSH does. With
+// trustOnFirstUse, keys of unknown hosts are appended to the first file.
+// The plain knownhosts callback is returned as well for lookups.
+func knownHostsCallback(files []string, trustOnFirstUse bool) (ssh.HostKeyCallback, ssh.HostKeyCallback, error) {
+	existing := make([]string, 0, len(files))
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, nil, err
+		}
+		existing = append(existing, file)
+	}
+
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			return &HostKeyChangedError{
+				Hostname: hostname,
+				OldKeys:  keyErr.Want,
+				NewKey:   key,
+			}
+		}
+
+		if !trustOnFirstUse {
+			return fmt.Errorf("ssh: host %s is not in known_hosts: %w", hostname, err)
+		}
+
+		return appendKnownHost(files[0], hostname, key)
+	}, callback, nil
+}
+
+// appendKnownHost records key for hostname at the end of file, creating the
+// file and its directory when needed.
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms matching the keys
+// recorded for address by the knownhosts callback, so that the server is
+// asked for a key that can actually be verified. It returns nil when no key
+// is recorded.
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, address string) []string {
+	var keyErr *knownhosts.KeyError
+	if err := callback(address, &net.TCPAddr{}, probeKey); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		keyType := known.Key.Type()
+		if seen[keyType] {
+			continue
+		}
+		seen[keyType] = true
+		if keyType == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, keyType)
+	}
+	return algorithms
+}
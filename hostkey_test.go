@@ -0,0 +1,247 @@
+package easyssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return key
+}
+
+func writeKnownHosts(t *testing.T, lines ...string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return file
+}
+
+func TestKnownHosts(t *testing.T) {
+	key := newTestHostKey(t)
+	other := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2222}
+
+	file := writeKnownHosts(t,
+		knownhosts.Line([]string{"example.com"}, key),
+		knownhosts.Line([]string{knownhosts.HashHostname("hashed.example.com")}, key),
+		knownhosts.Line([]string{"[example.com]:2222"}, other),
+	)
+
+	callback, algorithms, err := getHostKeyCallback(DefaultConfig{
+		Server:     "example.com",
+		Port:       "22",
+		KnownHosts: []string{file, filepath.Join(t.TempDir(), "missing")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, algorithms)
+
+	assert.NoError(t, callback("example.com:22", remote, key))
+	assert.NoError(t, callback("hashed.example.com:22", remote, key))
+	assert.NoError(t, callback("example.com:2222", remote, other))
+
+	// changed key
+	err = callback("example.com:22", remote, other)
+	var changed *HostKeyChangedError
+	if assert.True(t, errors.As(err, &changed)) {
+		assert.Equal(t, "example.com:22", changed.Hostname)
+		assert.Equal(t, other, changed.NewKey)
+		assert.Len(t, changed.OldKeys, 1)
+		assert.Equal(t, key.Marshal(), changed.OldKeys[0].Key.Marshal())
+	}
+
+	// unknown host
+	err = callback("unknown.example.com:22", remote, key)
+	var keyErr *knownhosts.KeyError
+	assert.True(t, errors.As(err, &keyErr))
+}
+
+func TestKnownHostsTrustOnFirstUse(t *testing.T) {
+	key := newTestHostKey(t)
+	other := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	file := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+
+	config := DefaultConfig{
+		KnownHosts:      []string{file},
+		TrustOnFirstUse: true,
+	}
+
+	callback, _, err := getHostKeyCallback(config)
+	assert.NoError(t, err)
+	assert.NoError(t, callback("new.example.com:2200", remote, key))
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, knownhosts.Line([]string{"[new.example.com]:2200"}, key)+"\n", string(content))
+
+	// the recorded key is now enforced
+	callback, _, err = getHostKeyCallback(config)
+	assert.NoError(t, err)
+	assert.NoError(t, callback("new.example.com:2200", remote, key))
+
+	var changed *HostKeyChangedError
+	assert.True(t, errors.As(callback("new.example.com:2200", remote, other), &changed))
+}
+
+func TestKnownHostsWithFingerprint(t *testing.T) {
+	key := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	file := writeKnownHosts(t, knownhosts.Line([]string{"example.com"}, key))
+
+	callback, _, err := getHostKeyCallback(DefaultConfig{
+		KnownHosts:  []string{file},
+		Fingerprint: "SHA256:wrong",
+	})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, callback("example.com:22", remote, key), ErrFingerprintMismatch)
+}
+
+func TestRunCommandWithKnownHosts(t *testing.T) {
+	hostKey, err := getHostPublicKeyFile("/etc/ssh/ssh_host_rsa_key.pub")
+	if err != nil {
+		t.Fatalf("getHostPublicKeyFile: %v", err)
+	}
+
+	ssh := &MakeConfig{
+		Server:     "localhost",
+		User:       "drone-scp",
+		Port:       "22",
+		KeyPath:    "./tests/.ssh/id_rsa",
+		KnownHosts: []string{writeKnownHosts(t, knownhosts.Line([]string{"localhost"}, hostKey))},
+	}
+
+	outStr, _, _, err := ssh.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+
+	// host key doesn't match
+	wrongKey, err := getHostPublicKeyFile("./tests/.ssh/id_rsa.pub")
+	if err != nil {
+		t.Fatalf("getHostPublicKeyFile: %v", err)
+	}
+	ssh.KnownHosts = []string{writeKnownHosts(t, knownhosts.Line([]string{"localhost"}, wrongKey))}
+	_, _, _, err = ssh.Run("whoami")
+	var changed *HostKeyChangedError
+	assert.True(t, errors.As(err, &changed))
+}
+
+func newTestCA(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	return ca
+}
+
+// newTestHostCertificate signs a host certificate for a fresh key with ca.
+func newTestHostCertificate(t *testing.T, ca ssh.Signer, validAfter, validBefore time.Time, principals ...string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             newTestHostKey(t),
+		CertType:        ssh.HostCert,
+		KeyId:           "host",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert: %v", err)
+	}
+	return cert
+}
+
+func TestHostCAKeys(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	now := time.Now()
+	ca := newTestCA(t)
+	authority := string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+	cert := newTestHostCertificate(t, ca, now.Add(-time.Hour), now.Add(time.Hour), "example.com")
+
+	callback, algorithms, err := getHostKeyCallback(DefaultConfig{
+		HostCAKeys: []string{string(ssh.MarshalAuthorizedKey(newTestCA(t).PublicKey())), authority},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, algorithms)
+
+	assert.NoError(t, callback("example.com:22", remote, cert))
+	// wrong principal
+	assert.Error(t, callback("www.example.com:22", remote, cert))
+	// expired
+	expired := newTestHostCertificate(t, ca, now.Add(-2*time.Hour), now.Add(-time.Hour), "example.com")
+	assert.Error(t, callback("example.com:22", remote, expired))
+	// unknown CA
+	unknown := newTestHostCertificate(t, newTestCA(t), now.Add(-time.Hour), now.Add(time.Hour), "example.com")
+	assert.Error(t, callback("example.com:22", remote, unknown))
+	// plain host keys are rejected without a fallback
+	assert.Error(t, callback("example.com:22", remote, newTestHostKey(t)))
+
+	// plain host keys fall back to known_hosts
+	key := newTestHostKey(t)
+	callback, algorithms, err = getHostKeyCallback(DefaultConfig{
+		Server:     "example.com",
+		Port:       "22",
+		HostCAKeys: []string{authority},
+		KnownHosts: []string{writeKnownHosts(t, knownhosts.Line([]string{"example.com"}, key))},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]string{}, hostCertAlgorithms...), ssh.KeyAlgoED25519), algorithms)
+	assert.NoError(t, callback("example.com:22", remote, cert))
+	assert.NoError(t, callback("example.com:22", remote, key))
+	assert.Error(t, callback("example.com:22", remote, newTestHostKey(t)))
+
+	// invalid CA key
+	_, _, err = getHostKeyCallback(DefaultConfig{HostCAKeys: []string{"not a key"}})
+	assert.Error(t, err)
+}
+
+func TestKnownHostsCertAuthority(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	now := time.Now()
+	ca := newTestCA(t)
+	key := newTestHostKey(t)
+
+	file := writeKnownHosts(t,
+		"@cert-authority *.example.com "+strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.PublicKey()))),
+		knownhosts.Line([]string{"example.com"}, key),
+	)
+
+	callback, algorithms, err := getHostKeyCallback(DefaultConfig{
+		Server:     "example.com",
+		Port:       "22",
+		KnownHosts: []string{file},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]string{}, hostCertAlgorithms...), ssh.KeyAlgoED25519), algorithms)
+	assert.NoError(t, callback("example.com:22", remote, key))
+
+	cert := newTestHostCertificate(t, ca, now.Add(-time.Hour), now.Add(time.Hour), "www.example.com")
+	assert.NoError(t, callback("www.example.com:22", remote, cert))
+	assert.Error(t, callback("ftp.example.com:22", remote, cert))
+}
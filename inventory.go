@@ -0,0 +1,335 @@
+package easyssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownGroup is returned by Inventory.Group for a group the inventory
+// does not define.
+var ErrUnknownGroup = errors.New("easyssh: unknown inventory group")
+
+type (
+	// Inventory describes a fleet of hosts, in YAML or JSON:
+	//
+	//	defaults:
+	//	  user: deploy
+	//	  key_path: /home/deploy/.ssh/id_ed25519
+	//	  proxy:
+	//	    server: bastion.example.com
+	//	groups:
+	//	  web:
+	//	    defaults:
+	//	      port: "2222"
+	//	      labels: {tier: frontend}
+	//	    hosts:
+	//	      web-1: {server: 10.0.0.1, labels: {env: prod}}
+	//	      web-2: {server: 10.0.0.2, labels: {env: staging}}
+	//
+	// Settings cascade from the global defaults to the group defaults to the
+	// host, each level overriding the fields it sets. Labels are merged the
+	// same way. A host without server is reached at its name.
+	Inventory struct {
+		Defaults InventorySettings         `yaml:"defaults" json:"defaults"`
+		Groups   map[string]InventoryGroup `yaml:"groups" json:"groups"`
+	}
+
+	// InventoryGroup is a named group of hosts sharing defaults. Host names
+	// are unique across the inventory.
+	InventoryGroup struct {
+		Defaults InventorySettings            `yaml:"defaults" json:"defaults"`
+		Hosts    map[string]InventorySettings `yaml:"hosts" json:"hosts"`
+	}
+
+	// InventorySettings are the settings of the inventory, of its groups and
+	// of its hosts. Empty fields are inherited.
+	InventorySettings struct {
+		Server     string            `yaml:"server" json:"server"`
+		User       string            `yaml:"user" json:"user"`
+		Port       string            `yaml:"port" json:"port"`
+		KeyPath    string            `yaml:"key_path" json:"key_path"`
+		Timeout    string            `yaml:"timeout" json:"timeout"`
+		KnownHosts []string          `yaml:"known_hosts" json:"known_hosts"`
+		Proxy      InventoryProxy    `yaml:"proxy" json:"proxy"`
+		Labels     map[string]string `yaml:"labels" json:"labels"`
+	}
+
+	// InventoryProxy is the proxy server of an inventory level. Its fields
+	// cascade like the other settings; a server of "none" disables an
+	// inherited proxy. User, KeyPath, Timeout and KnownHosts fall back to
+	// the settings of the host, the port to 22.
+	InventoryProxy struct {
+		Server     string   `yaml:"server" json:"server"`
+		User       string   `yaml:"user" json:"user"`
+		Port       string   `yaml:"port" json:"port"`
+		KeyPath    string   `yaml:"key_path" json:"key_path"`
+		Timeout    string   `yaml:"timeout" json:"timeout"`
+		KnownHosts []string `yaml:"known_hosts" json:"known_hosts"`
+	}
+
+	// InventoryHost is a host of the inventory with its resolved settings.
+	InventoryHost struct {
+		Name   string
+		Group  string
+		Labels map[string]string
+		Config *MakeConfig
+	}
+)
+
+// LoadInventory reads a YAML or JSON inventory file.
+func LoadInventory(path string) (*Inventory, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseInventory(buf)
+}
+
+// ParseInventory parses a YAML or JSON inventory and checks that its hosts
+// resolve. Unknown keys, such as a misspelled key_path, are rejected.
+func ParseInventory(data []byte) (*Inventory, error) {
+	inventory := &Inventory{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(inventory); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("easyssh: parse inventory: %w", err)
+	}
+	if _, err := inventory.Hosts(); err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+// Hosts returns every host of the inventory, ordered by group and name.
+func (inv *Inventory) Hosts() ([]*InventoryHost, error) {
+	groups := make([]string, 0, len(inv.Groups))
+	for name := range inv.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	seen := map[string]string{}
+	var hosts []*InventoryHost
+	for _, group := range groups {
+		groupHosts, err := inv.groupHosts(group)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range groupHosts {
+			if other, ok := seen[host.Name]; ok {
+				return nil, fmt.Errorf("easyssh: inventory host %s is in groups %s and %s", host.Name, other, group)
+			}
+			seen[host.Name] = group
+		}
+		hosts = append(hosts, groupHosts...)
+	}
+	return hosts, nil
+}
+
+// Group returns the hosts of the named group, ordered by name.
+func (inv *Inventory) Group(name string) ([]*InventoryHost, error) {
+	if _, ok := inv.Groups[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGroup, name)
+	}
+	return inv.groupHosts(name)
+}
+
+// Select returns the hosts whose labels match selector, a comma separated
+// list of requirements that must all hold: "key=value", "key!=value", "key"
+// for a label that is set and "!key" for one that is not.
+func (inv *Inventory) Select(selector string) ([]*InventoryHost, error) {
+	requirements, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := inv.Hosts()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*InventoryHost
+	for _, host := range hosts {
+		if requirements.matches(host.Labels) {
+			selected = append(selected, host)
+		}
+	}
+	return selected, nil
+}
+
+func (inv *Inventory) groupHosts(group string) ([]*InventoryHost, error) {
+	g := inv.Groups[group]
+	names := make([]string, 0, len(g.Hosts))
+	for name := range g.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hosts := make([]*InventoryHost, 0, len(names))
+	for _, name := range names {
+		settings := inv.Defaults.merge(g.Defaults).merge(g.Hosts[name])
+		config, err := settings.config(name)
+		if err != nil {
+			return nil, fmt.Errorf("easyssh: inventory host %s: %w", name, err)
+		}
+		hosts = append(hosts, &InventoryHost{
+			Name:   name,
+			Group:  group,
+			Labels: settings.Labels,
+			Config: config,
+		})
+	}
+	return hosts, nil
+}
+
+// merge returns s overridden by the fields set in o.
+func (s InventorySettings) merge(o InventorySettings) InventorySettings {
+	s.Server = override(s.Server, o.Server)
+	s.User = override(s.User, o.User)
+	s.Port = override(s.Port, o.Port)
+	s.KeyPath = override(s.KeyPath, o.KeyPath)
+	s.Timeout = override(s.Timeout, o.Timeout)
+	if o.KnownHosts != nil {
+		s.KnownHosts = o.KnownHosts
+	}
+
+	s.Proxy.Server = override(s.Proxy.Server, o.Proxy.Server)
+	s.Proxy.User = override(s.Proxy.User, o.Proxy.User)
+	s.Proxy.Port = override(s.Proxy.Port, o.Proxy.Port)
+	s.Proxy.KeyPath = override(s.Proxy.KeyPath, o.Proxy.KeyPath)
+	s.Proxy.Timeout = override(s.Proxy.Timeout, o.Proxy.Timeout)
+	if o.Proxy.KnownHosts != nil {
+		s.Proxy.KnownHosts = o.Proxy.KnownHosts
+	}
+
+	labels := make(map[string]string, len(s.Labels)+len(o.Labels))
+	for key, value := range s.Labels {
+		labels[key] = value
+	}
+	for key, value := range o.Labels {
+		labels[key] = value
+	}
+	s.Labels = labels
+	return s
+}
+
+func override(value, with string) string {
+	if with != "" {
+		return with
+	}
+	return value
+}
+
+// config returns the MakeConfig of the host name with the resolved settings.
+func (s InventorySettings) config(name string) (*MakeConfig, error) {
+	timeout, err := parseTimeout(s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &MakeConfig{
+		Server:     override(name, s.Server),
+		User:       s.User,
+		Port:       override("22", s.Port),
+		KeyPath:    s.KeyPath,
+		Timeout:    timeout,
+		KnownHosts: s.KnownHosts,
+	}
+
+	if s.Proxy.Server != "" && s.Proxy.Server != "none" {
+		proxyTimeout, err := parseTimeout(s.Proxy.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		if s.Proxy.Timeout == "" {
+			proxyTimeout = timeout
+		}
+		config.Proxy = DefaultConfig{
+			Server:     s.Proxy.Server,
+			User:       override(s.User, s.Proxy.User),
+			Port:       override("22", s.Proxy.Port),
+			KeyPath:    override(s.KeyPath, s.Proxy.KeyPath),
+			Timeout:    proxyTimeout,
+			KnownHosts: s.Proxy.KnownHosts,
+		}
+		if config.Proxy.KnownHosts == nil {
+			config.Proxy.KnownHosts = s.KnownHosts
+		}
+	}
+
+	return config, nil
+}
+
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	return d, nil
+}
+
+// labelRequirement is one requirement of a label selector.
+type labelRequirement struct {
+	key     string
+	value   string
+	exists  bool // "key" or "!key"
+	negated bool
+}
+
+type labelSelector []labelRequirement
+
+func parseSelector(selector string) (labelSelector, error) {
+	var requirements labelSelector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			key, value, _ := strings.Cut(term, "!=")
+			r = labelRequirement{key: strings.TrimSpace(key), value: strings.TrimSpace(value), negated: true}
+		case strings.Contains(term, "="):
+			key, value, _ := strings.Cut(term, "=")
+			r = labelRequirement{key: strings.TrimSpace(key), value: strings.TrimSpace(value)}
+		case strings.HasPrefix(term, "!"):
+			r = labelRequirement{key: strings.TrimSpace(term[1:]), exists: true, negated: true}
+		default:
+			r = labelRequirement{key: term, exists: true}
+		}
+		if r.key == "" {
+			return nil, fmt.Errorf("easyssh: invalid label selector %q", selector)
+		}
+		requirements = append(requirements, r)
+	}
+	return requirements, nil
+}
+
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+		var matched bool
+		if r.exists {
+			matched = ok
+		} else {
+			matched = ok && value == r.value
+		}
+		if matched == r.negated {
+			return false
+		}
+	}
+	return true
+}
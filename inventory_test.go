@@ -0,0 +1,184 @@
+package easyssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testInventory = `
+defaults:
+  user: deploy
+  key_path: /keys/deploy
+  timeout: 30s
+  proxy:
+    server: bastion.example.com
+  labels:
+    env: prod
+
+groups:
+  web:
+    defaults:
+      port: 2222
+      labels:
+        tier: frontend
+    hosts:
+      web-1:
+        server: 10.0.0.1
+      web-2:
+        server: 10.0.0.2
+        labels:
+          env: staging
+  db:
+    defaults:
+      user: dba
+      proxy:
+        user: jump
+        port: "2200"
+    hosts:
+      db-1:
+        key_path: /keys/db
+      db-internal:
+        proxy:
+          server: none
+`
+
+func hostNames(hosts []*InventoryHost) []string {
+	names := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return names
+}
+
+func TestInventoryCascade(t *testing.T) {
+	inventory, err := ParseInventory([]byte(testInventory))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	hosts, err := inventory.Hosts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db-1", "db-internal", "web-1", "web-2"}, hostNames(hosts))
+
+	web1 := hosts[2]
+	assert.Equal(t, "web", web1.Group)
+	assert.Equal(t, map[string]string{"env": "prod", "tier": "frontend"}, web1.Labels)
+	assert.Equal(t, "10.0.0.1", web1.Config.Server)
+	assert.Equal(t, "deploy", web1.Config.User)
+	assert.Equal(t, "2222", web1.Config.Port)
+	assert.Equal(t, "/keys/deploy", web1.Config.KeyPath)
+	assert.Equal(t, 30*time.Second, web1.Config.Timeout)
+	// the proxy inherits the settings of the host
+	assert.Equal(t, DefaultConfig{
+		Server:  "bastion.example.com",
+		User:    "deploy",
+		Port:    "22",
+		KeyPath: "/keys/deploy",
+		Timeout: 30 * time.Second,
+	}, web1.Config.Proxy)
+
+	assert.Equal(t, map[string]string{"env": "staging", "tier": "frontend"}, hosts[3].Labels)
+
+	db1 := hosts[0]
+	assert.Equal(t, "db-1", db1.Config.Server)
+	assert.Equal(t, "dba", db1.Config.User)
+	assert.Equal(t, "22", db1.Config.Port)
+	assert.Equal(t, "/keys/db", db1.Config.KeyPath)
+	assert.Equal(t, DefaultConfig{
+		Server:  "bastion.example.com",
+		User:    "jump",
+		Port:    "2200",
+		KeyPath: "/keys/db",
+		Timeout: 30 * time.Second,
+	}, db1.Config.Proxy)
+
+	// proxy disabled
+	assert.Empty(t, hosts[1].Config.Proxy.Server)
+}
+
+func TestInventorySelect(t *testing.T) {
+	inventory, err := ParseInventory([]byte(testInventory))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	hosts, err := inventory.Group("web")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web-1", "web-2"}, hostNames(hosts))
+
+	_, err = inventory.Group("cache")
+	assert.ErrorIs(t, err, ErrUnknownGroup)
+
+	for selector, want := range map[string][]string{
+		"":                        {"db-1", "db-internal", "web-1", "web-2"},
+		"env=prod":                {"db-1", "db-internal", "web-1"},
+		"env=prod,tier=frontend":  {"web-1"},
+		"env!=prod":               {"web-2"},
+		"tier":                    {"web-1", "web-2"},
+		"!tier":                   {"db-1", "db-internal"},
+		" env = staging , tier ":  {"web-2"},
+		"env=prod,tier=frontend,": {"web-1"},
+		"env=dev":                 nil,
+	} {
+		hosts, err := inventory.Select(selector)
+		assert.NoError(t, err)
+		if want == nil {
+			assert.Empty(t, hosts, selector)
+		} else {
+			assert.Equal(t, want, hostNames(hosts), selector)
+		}
+	}
+
+	_, err = inventory.Select("=prod")
+	assert.Error(t, err)
+}
+
+func TestLoadInventory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.json")
+	err := os.WriteFile(file, []byte(`{
+  "defaults": {"user": "drone-scp", "key_path": "./tests/.ssh/id_rsa"},
+  "groups": {"local": {"hosts": {"localhost": {"labels": {"env": "test"}}}}}
+}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	inventory, err := LoadInventory(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	hosts, err := inventory.Select("env=test")
+	assert.NoError(t, err)
+	if assert.Len(t, hosts, 1) {
+		outStr, _, _, err := hosts[0].Config.Run("whoami")
+		assert.Equal(t, "drone-scp\n", outStr)
+		assert.NoError(t, err)
+	}
+
+	_, err = LoadInventory(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"syntax":    "groups: [",
+		"timeout":   "groups: {web: {hosts: {web-1: {timeout: soon}}}}",
+		"duplicate": "groups: {a: {hosts: {web-1: {}}}, b: {hosts: {web-1: {}}}}",
+		"field":     "groups: {web: {hosts: {web-1: {keypath: /root/.ssh/id_rsa}}}}",
+		"proxy":     "defaults: {proxy: {sever: bastion}}",
+	} {
+		_, err := ParseInventory([]byte(data))
+		assert.Error(t, err, name)
+	}
+
+	_, err := ParseInventory([]byte(`{"groups": {"web": {"hosts": {"web-1": {"server": "10.0.0.1", "keypath": "id_rsa"}}}}}`))
+	assert.ErrorContains(t, err, "field keypath not found")
+
+	inventory, err := ParseInventory(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, inventory.Groups)
+}
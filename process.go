@@ -0,0 +1,170 @@
+package easyssh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultKillGracePeriod = 5 * time.Second
+	// killWait is how long a command is given to report its exit after
+	// SIGKILL before its session is closed.
+	killWait = time.Second
+)
+
+// processGroupScript runs the command given as $1 in a new process group,
+// with setsid or else the job control of sh, and records the group in the
+// file given as $2 so that it can be signaled from another session. Signals
+// sent to the script itself are forwarded to the group.
+const processGroupScript = `f=$2
+exec 3<&0
+if command -v setsid >/dev/null 2>&1; then
+	setsid sh -c "$1" <&3 3<&- &
+else
+	set -m 2>/dev/null
+	sh -c "$1" <&3 3<&- &
+fi
+exec 3<&-
+pid=$!
+echo $pid >"$f"
+trap 'kill -TERM -$pid 2>/dev/null' HUP INT TERM
+while :; do
+	wait $pid
+	s=$?
+	kill -0 $pid 2>/dev/null || break
+done
+rm -f "$f"
+exit $s`
+
+// remoteProcess is a command started in a session.
+type remoteProcess struct {
+	client  *Client
+	session *ssh.Session
+	// pgidFile holds the process group of the command when it was started
+	// with MakeConfig.ProcessGroup.
+	pgidFile string
+	// sudo is set when the command was started with MakeConfig.Sudo or
+	// WithSudo.
+	sudo *sudoSession
+	// stdinErr returns the error reading the input of the command, if any.
+	stdinErr func() error
+
+	// exited is closed once the command has exited, with the result of
+	// session.Wait in err.
+	exited chan struct{}
+	err    error
+}
+
+// wait waits for the command in the background and forwards the window
+// changes of MakeConfig.Pty until it exits.
+func (p *remoteProcess) wait() {
+	p.exited = make(chan struct{})
+	go func() {
+		err := p.session.Wait()
+		if p.sudo != nil {
+			if sudoErr := p.sudo.finish(); sudoErr != nil {
+				err = sudoErr
+			}
+		}
+		p.err = err
+		close(p.exited)
+	}()
+
+	if pty := p.client.config.Pty; pty != nil && pty.WindowChanges != nil {
+		go forwardWindowChanges(p.session, pty.WindowChanges, p.exited)
+	}
+}
+
+// processGroupCommand returns command wrapped to run in its own process
+// group and the remote file the group is recorded in.
+func processGroupCommand(command string) (string, string, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", "", err
+	}
+	pgidFile := "/tmp/.easyssh-" + hex.EncodeToString(token) + ".pgid"
+	return "sh -c " + shellQuote(processGroupScript) + " easyssh " + shellQuote(command) + " " + pgidFile, pgidFile, nil
+}
+
+// terminate stops the process when it has not exited yet: it is sent
+// SIGTERM and, when it is still running after the grace period, SIGKILL.
+func (p *remoteProcess) terminate(grace time.Duration) {
+	if grace > 0 {
+		p.signal(ssh.SIGTERM)
+		select {
+		case <-p.exited:
+			return
+		case <-time.After(grace):
+		}
+	}
+
+	p.signal(ssh.SIGKILL)
+	select {
+	case <-p.exited:
+	case <-time.After(killWait):
+	}
+}
+
+// killGracePeriod returns KillGracePeriod with its default, 0 meaning that
+// SIGKILL is sent right away.
+func (ssh_conf *MakeConfig) killGracePeriod() time.Duration {
+	switch {
+	case ssh_conf.KillGracePeriod == 0:
+		return defaultKillGracePeriod
+	case ssh_conf.KillGracePeriod < 0:
+		return 0
+	}
+	return ssh_conf.KillGracePeriod
+}
+
+// stop terminates the process in the background with the grace period of
+// MakeConfig.KillGracePeriod and closes its session afterwards, so that a
+// timeout is reported without waiting for the grace period. Client.Close
+// waits for it.
+func (p *remoteProcess) stop() {
+	c := p.client
+	c.stopMu.Lock()
+	if c.closing {
+		// the connection is going away, the signals would not be sent
+		c.stopMu.Unlock()
+		_ = p.session.Close()
+		return
+	}
+	c.stopCount++
+	c.stopping.Add(1)
+	c.stopMu.Unlock()
+
+	go func() {
+		defer c.stopping.Done()
+		p.terminate(c.config.killGracePeriod())
+		_ = p.session.Close()
+		c.stopMu.Lock()
+		c.stopCount--
+		c.stopMu.Unlock()
+	}()
+}
+
+// signal sends sig to the process and, with MakeConfig.ProcessGroup, to its
+// whole process group from a separate session. The file recording the group
+// is removed along with SIGKILL, which the script can not trap.
+func (p *remoteProcess) signal(sig ssh.Signal) {
+	_ = p.session.Signal(sig)
+	if p.pgidFile == "" {
+		return
+	}
+
+	session, err := p.client.client.NewSession()
+	if err != nil {
+		return
+	}
+	defer func() { _ = session.Close() }()
+	command := fmt.Sprintf(`pgid=$(cat %s 2>/dev/null) && kill -%s -"$pgid"`, p.pgidFile, sig)
+	if sig == ssh.SIGKILL {
+		command += "; rm -f " + p.pgidFile
+	}
+	_ = session.Run(command)
+}
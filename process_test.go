@@ -0,0 +1,142 @@
+package easyssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessGroupCommand(t *testing.T) {
+	command, pgidFile, err := processGroupCommand("echo 'hi'")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^/tmp/\.easyssh-[0-9a-f]{16}\.pgid$`, pgidFile)
+	assert.True(t, strings.HasPrefix(command, "sh -c 'f=$2\n"))
+	assert.True(t, strings.HasSuffix(command, ` easyssh 'echo '\''hi'\''' `+pgidFile))
+}
+
+func TestKillGracePeriod(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:          "localhost",
+		User:            "drone-scp",
+		Port:            "22",
+		KeyPath:         "./tests/.ssh/id_rsa",
+		KillGracePeriod: 500 * time.Millisecond,
+	}
+
+	// the timeout is reported right away, the command is stopped in the
+	// background
+	start := time.Now()
+	outStr, _, isTimeout, err := sshConf.Run("echo 1; sleep 5", 500*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.False(t, isTimeout)
+	assert.Error(t, err)
+	assert.Equal(t, "1\n", outStr)
+
+	running := func(pattern string) bool {
+		res, _ := sshConf.Exec("pgrep -f " + shellQuote(pattern))
+		return res.Stdout != ""
+	}
+
+	// SIGTERM is ignored, so the command is killed after the grace period,
+	// even though the connection of Exec is no longer used
+	sshConf.KillGracePeriod = 2 * time.Second
+	start = time.Now()
+	res, err := sshConf.Exec("trap '' TERM; echo started; exec sleep 37", 500*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.True(t, res.TimedOut)
+	assert.Equal(t, "started\n", res.Stdout)
+	assert.True(t, running("sleep 3[7]"))
+	assert.Eventually(t, func() bool { return !running("sleep 3[7]") }, 5*time.Second, 100*time.Millisecond)
+
+	// SIGTERM ends the command before the grace period
+	sshConf.KillGracePeriod = 10 * time.Second
+	_, _, isTimeout, err = sshConf.Run("exec sleep 36", 500*time.Millisecond)
+	assert.False(t, isTimeout)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return !running("sleep 3[6]") }, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestProcessGroup(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:          "localhost",
+		User:            "drone-scp",
+		Port:            "22",
+		KeyPath:         "./tests/.ssh/id_rsa",
+		KillGracePeriod: 500 * time.Millisecond,
+		ProcessGroup:    true,
+	}
+
+	res, err := sshConf.ExecContext(context.Background(), "cat; echo \"$0\" >&2; exit 3", WithStdin(strings.NewReader("input\n")))
+	assert.Equal(t, "input\n", res.Stdout)
+	assert.Equal(t, "sh\n", res.Stderr)
+	assert.Equal(t, 3, res.ExitCode)
+	assert.Error(t, err)
+
+	// the background child is stopped along with the shell
+	res, err = sshConf.Exec("sleep 38 & sleep 39; wait", 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.True(t, res.TimedOut)
+
+	// the group is recorded until it is killed, even when it ignores SIGTERM
+	res, err = sshConf.Exec("trap '' TERM; sleep 34 & sleep 33; wait", 500*time.Millisecond)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.True(t, res.TimedOut)
+
+	sshConf.ProcessGroup = false
+	assert.Eventually(t, func() bool {
+		res, err := sshConf.Exec(`pgrep -f "sleep 3[3489]"; ls /tmp/.easyssh-*.pgid`)
+		return res.Stdout == "" && err != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// the command would lose its terminal, and sudo its privileges
+	sshConf.ProcessGroup = true
+	sshConf.RequestPty = true
+	res, err = sshConf.Exec("tty")
+	assert.ErrorIs(t, err, ErrConflictingOption)
+	assert.Empty(t, res.Stdout)
+	_, err = sshConf.Expect("tty")
+	assert.ErrorIs(t, err, ErrConflictingOption)
+
+	sshConf.RequestPty = false
+	sshConf.Sudo = &SudoOptions{}
+	_, err = sshConf.Exec("sleep 32")
+	assert.ErrorIs(t, err, ErrConflictingOption)
+
+	sshConf.Sudo = nil
+	_, err = sshConf.ExecContext(context.Background(), "sleep 32", WithSudo(&SudoOptions{}))
+	assert.ErrorIs(t, err, ErrConflictingOption)
+	assert.ErrorContains(t, err, `ProcessGroup: can not be combined with "WithSudo"`)
+}
+
+func TestCloseStopsCommands(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:          "localhost",
+		User:            "drone-scp",
+		Port:            "22",
+		KeyPath:         "./tests/.ssh/id_rsa",
+		KillGracePeriod: time.Second,
+	}
+
+	c, err := sshConf.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	res, err := c.ExecContext(ctx, "trap '' TERM; echo started; exec sleep 31")
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.Equal(t, "started\n", res.Stdout)
+
+	// Close waits for the command to be killed after the grace period
+	start := time.Now()
+	assert.NoError(t, c.Close())
+	assert.Less(t, time.Since(start), sshConf.KillGracePeriod+killWait+time.Second)
+
+	res, err = sshConf.Exec(`pgrep -f "sleep 3[1]"`)
+	assert.Error(t, err)
+	assert.Empty(t, res.Stdout)
+}
@@ -0,0 +1,141 @@
+package easyssh
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultPtyTerm   = "xterm"
+	defaultPtyWidth  = 80
+	defaultPtyHeight = 40
+)
+
+type (
+	// PtyOptions configures the pseudo-terminal requested for the sessions
+	// when MakeConfig.Pty is set. Empty fields take the defaults used by
+	// MakeConfig.RequestPty: an xterm of 80 columns and 40 rows with echo
+	// disabled and 14400 baud.
+	PtyOptions struct {
+		// Term is the terminal type, the TERM of the remote commands.
+		Term string
+		// Width and Height are the size of the terminal in columns and
+		// rows.
+		Width  int
+		Height int
+		// Modes are the terminal modes, such as ssh.ECHO, ssh.ICANON or
+		// ssh.TTY_OP_OSPEED. They replace the default modes.
+		Modes ssh.TerminalModes
+		// WindowChanges delivers new terminal sizes while a command runs,
+		// which are forwarded to the server as window-change requests, for
+		// example when the local terminal is resized. Each size reaches
+		// every command running with the channel at the time, such as the
+		// commands run at the same time on a Client. Sizes sent while no
+		// command runs are left in the channel.
+		WindowChanges <-chan WindowSize
+	}
+
+	// WindowSize is the size of a terminal in columns and rows.
+	WindowSize struct {
+		Width  int
+		Height int
+	}
+)
+
+// pty returns the pseudo-terminal to request with the defaults filled in, or
+// nil when none is requested.
+func (ssh_conf *MakeConfig) pty() *PtyOptions {
+	if ssh_conf.Pty == nil && !ssh_conf.RequestPty {
+		return nil
+	}
+
+	pty := PtyOptions{}
+	if ssh_conf.Pty != nil {
+		pty = *ssh_conf.Pty
+	}
+	if pty.Term == "" {
+		pty.Term = defaultPtyTerm
+	}
+	if pty.Width <= 0 {
+		pty.Width = defaultPtyWidth
+	}
+	if pty.Height <= 0 {
+		pty.Height = defaultPtyHeight
+	}
+	if pty.Modes == nil {
+		pty.Modes = ssh.TerminalModes{
+			ssh.ECHO:          0,     // disable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+	}
+	return &pty
+}
+
+// windowChanger is the part of ssh.Session receiving the window changes.
+type windowChanger interface {
+	WindowChange(h, w int) error
+}
+
+// windowHubs holds the hub of each WindowChanges channel in use, so that a
+// channel is read once for all of its sessions.
+var windowHubs = struct {
+	sync.Mutex
+	hubs map[<-chan WindowSize]*windowHub
+}{hubs: map[<-chan WindowSize]*windowHub{}}
+
+// windowHub forwards the sizes of a channel to its sessions, guarded by
+// windowHubs, until stop is closed.
+type windowHub struct {
+	sessions map[windowChanger]struct{}
+	stop     chan struct{}
+}
+
+// forwardWindowChanges sends the sizes received from changes as
+// window-change requests to session until done is closed. The sessions
+// using changes at the same time all receive every size.
+func forwardWindowChanges(session windowChanger, changes <-chan WindowSize, done <-chan struct{}) {
+	windowHubs.Lock()
+	hub := windowHubs.hubs[changes]
+	if hub == nil {
+		hub = &windowHub{sessions: map[windowChanger]struct{}{}, stop: make(chan struct{})}
+		windowHubs.hubs[changes] = hub
+		go hub.run(changes)
+	}
+	hub.sessions[session] = struct{}{}
+	windowHubs.Unlock()
+
+	<-done
+
+	windowHubs.Lock()
+	delete(hub.sessions, session)
+	if len(hub.sessions) == 0 {
+		// leave the next sizes in the channel until a command runs
+		delete(windowHubs.hubs, changes)
+		close(hub.stop)
+	}
+	windowHubs.Unlock()
+}
+
+func (h *windowHub) run(changes <-chan WindowSize) {
+	for {
+		select {
+		case size, ok := <-changes:
+			if !ok {
+				return
+			}
+			windowHubs.Lock()
+			sessions := make([]windowChanger, 0, len(h.sessions))
+			for session := range h.sessions {
+				sessions = append(sessions, session)
+			}
+			windowHubs.Unlock()
+			for _, session := range sessions {
+				_ = session.WindowChange(size.Height, size.Width)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package easyssh
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPtyDefaults(t *testing.T) {
+	assert.Nil(t, (&MakeConfig{}).pty())
+
+	pty := (&MakeConfig{RequestPty: true}).pty()
+	if assert.NotNil(t, pty) {
+		assert.Equal(t, "xterm", pty.Term)
+		assert.Equal(t, 80, pty.Width)
+		assert.Equal(t, 40, pty.Height)
+		assert.Equal(t, uint32(0), pty.Modes[ssh.ECHO])
+	}
+
+	options := &PtyOptions{Term: "vt100", Height: 50, Modes: ssh.TerminalModes{ssh.ECHO: 1}}
+	pty = (&MakeConfig{Pty: options}).pty()
+	if assert.NotNil(t, pty) {
+		assert.Equal(t, "vt100", pty.Term)
+		assert.Equal(t, 80, pty.Width)
+		assert.Equal(t, 50, pty.Height)
+		assert.Equal(t, ssh.TerminalModes{ssh.ECHO: 1}, pty.Modes)
+	}
+	// the options are not modified
+	assert.Equal(t, 0, options.Width)
+}
+
+// fakeWindow records the window changes of a session.
+type fakeWindow struct {
+	mu    sync.Mutex
+	sizes []WindowSize
+}
+
+func (w *fakeWindow) WindowChange(h, width int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sizes = append(w.sizes, WindowSize{Width: width, Height: h})
+	return nil
+}
+
+func (w *fakeWindow) received() []WindowSize {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WindowSize(nil), w.sizes...)
+}
+
+func TestForwardWindowChanges(t *testing.T) {
+	changes := make(chan WindowSize)
+	first, second := &fakeWindow{}, &fakeWindow{}
+	firstDone, secondDone := make(chan struct{}), make(chan struct{})
+	go forwardWindowChanges(first, changes, firstDone)
+	go forwardWindowChanges(second, changes, secondDone)
+	assert.Eventually(t, func() bool {
+		windowHubs.Lock()
+		defer windowHubs.Unlock()
+		hub := windowHubs.hubs[changes]
+		return hub != nil && len(hub.sessions) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// every session running at the same time gets each size
+	size := WindowSize{Width: 100, Height: 30}
+	changes <- size
+	assert.Eventually(t, func() bool {
+		return len(first.received()) == 1 && len(second.received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []WindowSize{size}, first.received())
+	assert.Equal(t, []WindowSize{size}, second.received())
+
+	close(firstDone)
+	close(secondDone)
+	assert.Eventually(t, func() bool {
+		windowHubs.Lock()
+		defer windowHubs.Unlock()
+		return windowHubs.hubs[changes] == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// sizes sent while no command runs are left in the channel
+	select {
+	case changes <- size:
+		t.Fatal("the channel is still read")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPty(t *testing.T) {
+	changes := make(chan WindowSize, 1)
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+		Pty: &PtyOptions{
+			Term:          "vt100",
+			Width:         132,
+			Height:        50,
+			WindowChanges: changes,
+		},
+	}
+
+	res, err := sshConf.Exec(`echo "$TERM"; stty size`)
+	assert.NoError(t, err)
+	assert.Equal(t, "vt100\r\n50 132\r\n", res.Stdout)
+
+	changes <- WindowSize{Width: 100, Height: 30}
+	res, err = sshConf.Exec("sleep 0.5; stty size")
+	assert.NoError(t, err)
+	assert.Equal(t, "30 100\r\n", res.Stdout)
+
+	// stty prints the rows, then the columns
+	sshConf.Pty = nil
+	sshConf.RequestPty = true
+	res, err = sshConf.Exec(`echo "$TERM"; stty size`)
+	assert.NoError(t, err)
+	assert.Equal(t, "xterm\r\n40 80\r\n", res.Stdout)
+}
@@ -0,0 +1,157 @@
+package easyssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Shell opens an interactive login shell on the remote machine, through the
+// proxies if any, and bridges it to stdin, stdout and stderr until it exits.
+// A non-zero exit status of the shell is returned as an *ExitError.
+//
+// The shell always has a pseudo-terminal, configured by MakeConfig.Pty,
+// which echoes the input unless Pty.Modes are set. When stdin is a
+// terminal, it is put into raw mode for the duration of the session, its
+// size and TERM are used unless set in MakeConfig.Pty, and its size changes
+// are forwarded to the server. MakeConfig.Env is sent as env requests only,
+// the server may reject them.
+//
+// stdin is read in the background. When it is an *os.File, such as
+// os.Stdin, the pending read is interrupted once the shell exits, so the
+// next input is left to the caller. Any other reader is owned by the
+// session until its pending Read returns, whose data is then dropped.
+func (ssh_conf *MakeConfig) Shell(stdin io.Reader, stdout, stderr io.Writer) error {
+	return ssh_conf.ShellContext(context.Background(), stdin, stdout, stderr)
+}
+
+// ShellContext is like Shell but the connection and the shell are closed
+// when ctx is done.
+func (ssh_conf *MakeConfig) ShellContext(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+	c, err := ssh_conf.DialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	return c.ShellContext(ctx, stdin, stdout, stderr)
+}
+
+// Shell opens an interactive login shell in a new session. See
+// MakeConfig.Shell.
+func (c *Client) Shell(stdin io.Reader, stdout, stderr io.Writer) error {
+	return c.ShellContext(context.Background(), stdin, stdout, stderr)
+}
+
+// ShellContext is like Shell but the session is closed when ctx is done; the
+// Client itself stays open.
+func (c *Client) ShellContext(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+	pty := c.config.shellPty()
+
+	// the local terminal, if any
+	var fd int
+	local, isTerminal := stdin.(*os.File)
+	if isTerminal {
+		fd = int(local.Fd())
+		isTerminal = term.IsTerminal(fd)
+	}
+	if isTerminal {
+		if c.config.Pty == nil || c.config.Pty.Term == "" {
+			if name := os.Getenv("TERM"); name != "" {
+				pty.Term = name
+			}
+		}
+		if width, height, err := term.GetSize(fd); err == nil {
+			if c.config.Pty == nil || c.config.Pty.Width <= 0 {
+				pty.Width = width
+			}
+			if c.config.Pty == nil || c.config.Pty.Height <= 0 {
+				pty.Height = height
+			}
+		}
+	}
+
+	session, err := c.newSession(pty)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+
+	for _, name := range envNames(c.config.Env) {
+		_ = session.Setenv(name, c.config.Env[name])
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if local != nil {
+		reader, cancel := cancelableReader(local)
+		defer cancel()
+		stdin = reader
+	}
+	stdinErr, err := copyStdin(session, stdin)
+	if err != nil {
+		return err
+	}
+
+	if isTerminal {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = term.Restore(fd, state) }()
+	}
+
+	if err := session.Shell(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	defer close(exited)
+	if c.config.Pty != nil && c.config.Pty.WindowChanges != nil {
+		go forwardWindowChanges(session, c.config.Pty.WindowChanges, exited)
+	}
+	if isTerminal {
+		resizes, stop := notifyResize(fd)
+		defer stop()
+		go forwardWindowChanges(session, resizes, exited)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- session.Wait() }()
+
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if readErr := stdinErr(); readErr != nil {
+		return readErr
+	}
+	return exitError(net.JoinHostPort(c.config.Server, c.config.Port), err)
+}
+
+// shellPty returns the pseudo-terminal of Shell: that of MakeConfig.Pty with
+// the defaults filled in, but with echo enabled unless Modes are set, since
+// the local terminal in raw mode does not echo the input itself.
+func (ssh_conf *MakeConfig) shellPty() *PtyOptions {
+	pty := (&MakeConfig{Pty: ssh_conf.Pty, RequestPty: true}).pty()
+	if ssh_conf.Pty == nil || ssh_conf.Pty.Modes == nil {
+		pty.Modes = ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+	}
+	return pty
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package easyssh
+
+import (
+	"io"
+	"os"
+)
+
+// notifyResize returns no size changes on systems without SIGWINCH.
+func notifyResize(fd int) (<-chan WindowSize, func()) {
+	return nil, func() {}
+}
+
+// cancelableReader returns f unchanged: a Read pending when the shell exits
+// is left running, see Client.ShellContext.
+func cancelableReader(f *os.File) (io.Reader, func()) {
+	return f, func() {}
+}
@@ -0,0 +1,67 @@
+package easyssh
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestShellPty(t *testing.T) {
+	pty := (&MakeConfig{}).shellPty()
+	assert.Equal(t, "xterm", pty.Term)
+	assert.Equal(t, uint32(1), pty.Modes[ssh.ECHO])
+
+	pty = (&MakeConfig{RequestPty: true, Pty: &PtyOptions{Width: 100}}).shellPty()
+	assert.Equal(t, 100, pty.Width)
+	assert.Equal(t, uint32(1), pty.Modes[ssh.ECHO])
+
+	modes := ssh.TerminalModes{ssh.ECHO: 0}
+	pty = (&MakeConfig{Pty: &PtyOptions{Modes: modes}}).shellPty()
+	assert.Equal(t, modes, pty.Modes)
+}
+
+func TestShell(t *testing.T) {
+	sshConf := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+		Pty:     &PtyOptions{Width: 100, Height: 30},
+	}
+
+	var stdout bytes.Buffer
+	err := sshConf.Shell(strings.NewReader("stty size; echo \"$TERM\"; whoami\nexit 3\n"), &stdout, nil)
+	var exitErr *ExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, 3, exitErr.ExitCode)
+	}
+	assert.Contains(t, stdout.String(), "30 100\r\nxterm\r\ndrone-scp\r\n")
+	// the terminal echoes the input
+	assert.Contains(t, stdout.String(), "exit 3\r\n")
+
+	stdout.Reset()
+	err = sshConf.Shell(strings.NewReader("exit\n"), &stdout, nil)
+	assert.NoError(t, err)
+
+	// a file is no longer read once the shell has exited
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	defer func() { _ = w.Close() }()
+	_, err = w.WriteString("exit\n")
+	assert.NoError(t, err)
+	assert.NoError(t, sshConf.Shell(r, nil, nil))
+	_, err = w.WriteString("next\n")
+	assert.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := r.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "next\n", string(buf[:n]))
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package easyssh
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// notifyResize returns the new sizes of the terminal fd on SIGWINCH, until
+// stop is called.
+func notifyResize(fd int) (<-chan WindowSize, func()) {
+	sigwinch := make(chan os.Signal, 1)
+	signal.Notify(sigwinch, syscall.SIGWINCH)
+
+	sizes := make(chan WindowSize)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigwinch:
+			case <-done:
+				return
+			}
+			width, height, err := term.GetSize(fd)
+			if err != nil {
+				continue
+			}
+			select {
+			case sizes <- WindowSize{Width: width, Height: height}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return sizes, func() {
+		signal.Stop(sigwinch)
+		close(done)
+	}
+}
+
+// cancelableReader returns a reader of f whose pending Read returns io.EOF
+// once cancel is called, so that no goroutine is left reading f, such as
+// os.Stdin, after a shell has exited. It reads a duplicate of f in
+// non-blocking mode through the runtime poller; cancel restores the blocking
+// mode. Files that can not be polled, such as regular files, are returned
+// unchanged.
+func cancelableReader(f *os.File) (io.Reader, func()) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return f, func() {}
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return f, func() {}
+	}
+	dup := os.NewFile(uintptr(fd), f.Name())
+	if err := dup.SetReadDeadline(time.Time{}); err != nil {
+		_ = syscall.SetNonblock(fd, false)
+		_ = dup.Close()
+		return f, func() {}
+	}
+
+	reader := readerFunc(func(p []byte) (int, error) {
+		n, err := dup.Read(p)
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, os.ErrClosed) {
+			err = io.EOF
+		}
+		return n, err
+	})
+	return reader, func() {
+		_ = dup.SetReadDeadline(time.Now())
+		// the file description is shared with f
+		_ = syscall.SetNonblock(fd, false)
+		_ = dup.Close()
+	}
+}
@@ -0,0 +1,51 @@
+//go:build unix
+
+package easyssh
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelableReader(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	defer func() { _ = w.Close() }()
+
+	reader, cancel := cancelableReader(r)
+	_, err = w.WriteString("first")
+	assert.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := reader.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(buf[:n]))
+
+	// a pending read ends with io.EOF once canceled
+	read := make(chan error, 1)
+	go func() {
+		_, err := reader.Read(buf)
+		read <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-read:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("Read was not interrupted")
+	}
+
+	// the next input is left to the file, which blocks again
+	_, err = w.WriteString("second")
+	assert.NoError(t, err)
+	n, err = r.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(buf[:n]))
+}
@@ -0,0 +1,537 @@
+package easyssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnsupportedProxyCommand is returned by FromSSHConfig for a ProxyCommand
+// other than an "ssh -W %h:%p" jump, which is turned into a proxy hop.
+var ErrUnsupportedProxyCommand = errors.New("easyssh: unsupported ProxyCommand")
+
+// maxSSHConfigDepth bounds nested Include directives and ProxyJump chains.
+const maxSSHConfigDepth = 16
+
+// FromSSHConfig returns the configuration of alias found in the OpenSSH client
+// configuration files at paths, or in ~/.ssh/config and /etc/ssh/ssh_config
+// when no paths are given. Missing default files are skipped.
+//
+// HostName, User, Port, IdentityFile, ProxyJump, ProxyCommand, Ciphers,
+// KexAlgorithms, UserKnownHostsFile and ConnectTimeout are resolved with
+// Host and Match blocks and Include directives as ssh(1) does: the first
+// value obtained for a keyword wins. Relative Include paths are looked up
+// in the directory of the top-level file, such as ~/.ssh for ~/.ssh/config
+// and /etc/ssh for /etc/ssh/ssh_config, even in included files. The first IdentityFile that exists is used as
+// KeyPath. Jump hosts are resolved through the configuration as well, the
+// first one becoming Proxy and the others ProxyJump. Only ProxyCommand jumps
+// of the form "ssh -W %h:%p host" are supported. Match exec is never
+// considered to match.
+func FromSSHConfig(alias string, paths ...string) (*MakeConfig, error) {
+	return fromSSHConfig(alias, paths, 0)
+}
+
+func fromSSHConfig(alias string, paths []string, depth int) (*MakeConfig, error) {
+	if depth > maxSSHConfigDepth {
+		return nil, fmt.Errorf("easyssh: ProxyJump chain of %s is too long", alias)
+	}
+
+	p, err := newSSHConfigParser(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		for _, file := range []string{filepath.Join(p.home, ".ssh", "config"), "/etc/ssh/ssh_config"} {
+			if err := p.parseFile(file, filepath.Dir(file), 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	} else {
+		for _, file := range paths {
+			if err := p.parseFile(file, filepath.Dir(file), 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	config := &MakeConfig{
+		Server: p.hostname(),
+		User:   p.user(),
+		Port:   p.port(),
+	}
+
+	if len(p.identityFiles) > 0 {
+		config.KeyPath = p.expandPath(p.identityFiles[0])
+		for _, file := range p.identityFiles {
+			if _, err := os.Stat(p.expandPath(file)); err == nil {
+				config.KeyPath = p.expandPath(file)
+				break
+			}
+		}
+	}
+
+	if args, ok := p.values["userknownhostsfile"]; ok && !strings.EqualFold(args[0], "none") {
+		for _, file := range args {
+			config.KnownHosts = append(config.KnownHosts, p.expandPath(file))
+		}
+	}
+
+	var defaults ssh.Config
+	defaults.SetDefaults()
+	if args, ok := p.values["ciphers"]; ok {
+		if config.Ciphers, err = algorithmList(args[0], defaults.Ciphers); err != nil {
+			return nil, err
+		}
+	}
+	if args, ok := p.values["kexalgorithms"]; ok {
+		if config.KeyExchanges, err = algorithmList(args[0], defaults.KeyExchanges); err != nil {
+			return nil, err
+		}
+	}
+
+	if args, ok := p.values["connecttimeout"]; ok && !strings.EqualFold(args[0], "none") {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("easyssh: invalid ConnectTimeout %q", args[0])
+		}
+		config.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	jumps, err := p.jumpHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, jump := range jumps {
+		hops, err := jumpHops(jump, paths, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		config.ProxyJump = append(config.ProxyJump, hops...)
+	}
+	if len(config.ProxyJump) > 0 {
+		config.Proxy = config.ProxyJump[0]
+		config.ProxyJump = config.ProxyJump[1:]
+	}
+	if len(config.ProxyJump) == 0 {
+		config.ProxyJump = nil
+	}
+
+	return config, nil
+}
+
+// sshConfigParser collects the values that apply to one host while reading
+// configuration files.
+type sshConfigParser struct {
+	alias     string
+	localUser string
+	home      string
+
+	// values holds the first arguments obtained for each lower-case
+	// keyword, identityFiles every IdentityFile in order.
+	values        map[string][]string
+	identityFiles []string
+}
+
+func newSSHConfigParser(alias string) (*sshConfigParser, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	localUser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	return &sshConfigParser{
+		alias:     alias,
+		localUser: localUser,
+		home:      home,
+		values:    map[string][]string{},
+	}, nil
+}
+
+// parseFile parses file, included from a top-level file in dir, against
+// which relative Include paths are resolved.
+func (p *sshConfigParser) parseFile(file, dir string, depth int) error {
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("easyssh: %s: too many nested Include directives", file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	active := true
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("easyssh: %s line %d: %w", file, lineno, err)
+		}
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			active = matchHost(p.alias, args)
+		case "match":
+			if active, err = p.match(args); err != nil {
+				return fmt.Errorf("easyssh: %s line %d: %w", file, lineno, err)
+			}
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range args {
+				pattern = p.expandPath(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(dir, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("easyssh: %s line %d: %w", file, lineno, err)
+				}
+				for _, match := range matches {
+					if err := p.parseFile(match, dir, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if !active {
+				continue
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("easyssh: %s line %d: missing argument for %s", file, lineno, keyword)
+			}
+			switch keyword {
+			case "identityfile":
+				p.identityFiles = append(p.identityFiles, args[0])
+			case "proxyjump", "proxycommand":
+				// the first of the two wins
+				if _, ok := p.values["proxy"]; !ok {
+					p.values["proxy"] = append([]string{keyword}, args...)
+				}
+			default:
+				if _, ok := p.values[keyword]; !ok {
+					p.values[keyword] = args
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSSHConfigLine returns the lower-case keyword and the arguments of line,
+// or no keyword for blank lines and comments.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, errors.New("unterminated quoted argument")
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			arg, rest = rest[:end], rest[end:]
+		}
+		if arg == "" || arg[0] != '#' {
+			args = append(args, arg)
+		} else {
+			break
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// matchHost reports whether host matches the Host patterns: at least one
+// pattern matches and no negated pattern does.
+func matchHost(host string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated := strings.HasPrefix(pattern, "!"); negated {
+			if matchPattern(host, pattern[1:]) {
+				return false
+			}
+		} else if matchPattern(host, pattern) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPatternList reports whether s matches the comma separated pattern
+// list, with the semantics of matchHost.
+func matchPatternList(s, list string) bool {
+	return matchHost(s, strings.Split(list, ","))
+}
+
+// matchPattern matches s against a pattern where * matches any run of
+// characters and ? any single character, ignoring case.
+func matchPattern(s, pattern string) bool {
+	expr := regexp.QuoteMeta(strings.ToLower(pattern))
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	matched, _ := regexp.MatchString("^"+expr+"$", strings.ToLower(s))
+	return matched
+}
+
+// match evaluates the criteria of a Match line against the values obtained so
+// far.
+func (p *sshConfigParser) match(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, errors.New("missing Match criteria")
+	}
+
+	matched := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negated := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var result bool
+		switch criterion {
+		case "all":
+			result = true
+		case "final":
+			result = true
+		case "canonical":
+			result = false
+		case "host", "originalhost", "user", "localuser", "exec", "localnetwork", "tagged":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("missing argument for Match %s", criterion)
+			}
+			i++
+			switch criterion {
+			case "host":
+				result = matchPatternList(p.hostname(), args[i])
+			case "originalhost":
+				result = matchPatternList(p.alias, args[i])
+			case "user":
+				result = matchPatternList(p.user(), args[i])
+			case "localuser":
+				result = matchPatternList(p.localUser, args[i])
+			default:
+				// local commands are not run and local networks are not
+				// inspected
+				result = false
+			}
+		default:
+			return false, fmt.Errorf("unsupported Match criteria %q", args[i])
+		}
+
+		if result == negated {
+			matched = false
+		}
+	}
+	return matched, nil
+}
+
+func (p *sshConfigParser) hostname() string {
+	if args, ok := p.values["hostname"]; ok {
+		return strings.NewReplacer("%%", "%", "%h", p.alias).Replace(args[0])
+	}
+	return p.alias
+}
+
+func (p *sshConfigParser) user() string {
+	if args, ok := p.values["user"]; ok {
+		return args[0]
+	}
+	return p.localUser
+}
+
+func (p *sshConfigParser) port() string {
+	if args, ok := p.values["port"]; ok {
+		return args[0]
+	}
+	return "22"
+}
+
+// expandPath expands a leading ~ and the tokens of file.
+func (p *sshConfigParser) expandPath(file string) string {
+	if file == "~" || strings.HasPrefix(file, "~/") {
+		file = p.home + file[1:]
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", p.home,
+		"%u", p.localUser,
+		"%h", p.hostname(),
+		"%n", p.alias,
+		"%p", p.port(),
+		"%r", p.user(),
+	).Replace(file)
+}
+
+// jumpHosts returns the jump hosts of ProxyJump, or of a ProxyCommand running
+// "ssh -W %h:%p", as [user@]host[:port] specifications.
+func (p *sshConfigParser) jumpHosts() ([]string, error) {
+	args, ok := p.values["proxy"]
+	if !ok || strings.EqualFold(args[1], "none") {
+		return nil, nil
+	}
+
+	if args[0] == "proxyjump" {
+		return strings.Split(args[1], ","), nil
+	}
+
+	command := args[1:]
+	if len(command) == 0 || filepath.Base(command[0]) != "ssh" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxyCommand, strings.Join(command, " "))
+	}
+
+	var host, user, port, forward string
+	for i := 1; i < len(command); i++ {
+		arg := command[i]
+		switch {
+		case arg == "-W" || arg == "-p" || arg == "-l" || arg == "-o" || arg == "-i" || arg == "-F" || arg == "-J":
+			if i+1 >= len(command) {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxyCommand, strings.Join(command, " "))
+			}
+			i++
+			switch arg {
+			case "-W":
+				forward = command[i]
+			case "-p":
+				port = command[i]
+			case "-l":
+				user = command[i]
+			case "-J", "-F":
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxyCommand, strings.Join(command, " "))
+			}
+		case strings.HasPrefix(arg, "-"):
+			// flags without arguments such as -q, -T or -A
+		case host == "":
+			host = arg
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxyCommand, strings.Join(command, " "))
+		}
+	}
+
+	if host == "" || (forward != "%h:%p" && forward != "[%h]:%p") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxyCommand, strings.Join(command, " "))
+	}
+	if user != "" && !strings.Contains(host, "@") {
+		host = user + "@" + host
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return []string{host}, nil
+}
+
+// jumpHops resolves the jump host spec through the configuration files,
+// returning the hops to dial it through followed by the jump host itself.
+func jumpHops(spec string, paths []string, depth int) ([]DefaultConfig, error) {
+	var user, port string
+	if strings.HasPrefix(spec, "ssh://") {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		user, spec, port = u.User.Username(), u.Hostname(), u.Port()
+	} else {
+		if at := strings.LastIndex(spec, "@"); at >= 0 {
+			user, spec = spec[:at], spec[at+1:]
+		}
+		if host, p, err := net.SplitHostPort(spec); err == nil {
+			spec, port = host, p
+		}
+	}
+
+	jump, err := fromSSHConfig(spec, paths, depth)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		jump.User = user
+	}
+	if port != "" {
+		jump.Port = port
+	}
+
+	var hops []DefaultConfig
+	if jump.Proxy.Server != "" {
+		hops = append(hops, jump.Proxy)
+	}
+	hops = append(hops, jump.ProxyJump...)
+	return append(hops, DefaultConfig{
+		User:         jump.User,
+		Server:       jump.Server,
+		Port:         jump.Port,
+		KeyPath:      jump.KeyPath,
+		Timeout:      jump.Timeout,
+		Ciphers:      jump.Ciphers,
+		KeyExchanges: jump.KeyExchanges,
+		KnownHosts:   jump.KnownHosts,
+	}), nil
+}
+
+// algorithmList applies an OpenSSH algorithm list to defaults: "+" appends to
+// the defaults, "-" removes the matching ones, "^" puts the list first and a
+// plain list replaces them.
+func algorithmList(list string, defaults []string) ([]string, error) {
+	if list == "" {
+		return nil, errors.New("easyssh: empty algorithm list")
+	}
+
+	names := strings.Split(list[1:], ",")
+	switch list[0] {
+	case '+':
+		return append(append([]string{}, defaults...), names...), nil
+	case '^':
+		for _, name := range defaults {
+			if !matchPatternList(name, list[1:]) {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	case '-':
+		var kept []string
+		for _, name := range defaults {
+			if !matchPatternList(name, list[1:]) {
+				kept = append(kept, name)
+			}
+		}
+		return kept, nil
+	default:
+		return strings.Split(list, ","), nil
+	}
+}
@@ -0,0 +1,239 @@
+package easyssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSSHConfig(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return file
+}
+
+func TestFromSSHConfig(t *testing.T) {
+	dir := t.TempDir()
+	key := writeSSHConfig(t, dir, "id_ed25519", "key")
+	file := writeSSHConfig(t, dir, "config",
+		"# deploy targets",
+		"Host web-* !web-test",
+		"  HostName %h.example.com",
+		"  User deploy",
+		"  Port 2222",
+		"  IdentityFile "+filepath.Join(dir, "missing"),
+		"  IdentityFile "+key,
+		"  UserKnownHostsFile "+filepath.Join(dir, "known_hosts")+" "+filepath.Join(dir, "known_hosts2"),
+		"  ConnectTimeout=15",
+		"",
+		"Host web-1",
+		"  User ignored",
+		"",
+		"Host *",
+		"  User fallback",
+		"  Port 22",
+		`  Ciphers "aes256-gcm@openssh.com,aes128-ctr"`,
+		"  KexAlgorithms -*sha1*,ecdh-sha2-nistp*",
+	)
+
+	config, err := FromSSHConfig("web-1", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "web-1.example.com", config.Server)
+	assert.Equal(t, "deploy", config.User)
+	assert.Equal(t, "2222", config.Port)
+	assert.Equal(t, key, config.KeyPath)
+	assert.Equal(t, []string{filepath.Join(dir, "known_hosts"), filepath.Join(dir, "known_hosts2")}, config.KnownHosts)
+	assert.Equal(t, 15*time.Second, config.Timeout)
+	assert.Equal(t, []string{"aes256-gcm@openssh.com", "aes128-ctr"}, config.Ciphers)
+	assert.NotEmpty(t, config.KeyExchanges)
+	for _, kex := range config.KeyExchanges {
+		assert.NotContains(t, kex, "sha1")
+		assert.NotContains(t, kex, "ecdh-sha2-nistp")
+	}
+	assert.Empty(t, config.Proxy.Server)
+	assert.Nil(t, config.ProxyJump)
+
+	// negated pattern
+	config, err = FromSSHConfig("web-test", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "web-test", config.Server)
+	assert.Equal(t, "fallback", config.User)
+	assert.Equal(t, "22", config.Port)
+	assert.Empty(t, config.KeyPath)
+	assert.Nil(t, config.KnownHosts)
+
+	// missing file
+	_, err = FromSSHConfig("web-1", filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFromSSHConfigMatch(t *testing.T) {
+	dir := t.TempDir()
+	file := writeSSHConfig(t, dir, "config",
+		"Host db",
+		"  HostName db.internal",
+		"Match host *.internal !user admin",
+		"  User dba",
+		"Match originalhost db exec \"test -f /etc/passwd\"",
+		"  Port 2200",
+		"Match originalhost db",
+		"  Port 5022",
+		"Match all",
+		"  ConnectTimeout 5",
+	)
+
+	config, err := FromSSHConfig("db", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "db.internal", config.Server)
+	assert.Equal(t, "dba", config.User)
+	assert.Equal(t, "5022", config.Port)
+	assert.Equal(t, 5*time.Second, config.Timeout)
+
+	config, err = FromSSHConfig("other", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "22", config.Port)
+	assert.NotEqual(t, "dba", config.User)
+
+	file = writeSSHConfig(t, dir, "invalid", "Match version 1")
+	_, err = FromSSHConfig("db", file)
+	assert.Error(t, err)
+}
+
+func TestFromSSHConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	// relative to the directory of config, not of conf.d/10-app
+	writeSSHConfig(t, dir, "conf.d/10-app", "Host app", "  User app", "  Include port.d/app")
+	writeSSHConfig(t, dir, "port.d/app", "Port 2022")
+	writeSSHConfig(t, dir, "conf.d/20-db", "Host db", "  User db")
+	writeSSHConfig(t, dir, "only-app", "  HostName app.example.com")
+	file := writeSSHConfig(t, dir, "config",
+		"Include conf.d/*",
+		"Host app",
+		"  Include only-app",
+		"  User ignored",
+		"Host db",
+		"  Include only-app",
+	)
+
+	config, err := FromSSHConfig("app", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "app.example.com", config.Server)
+	assert.Equal(t, "app", config.User)
+	assert.Equal(t, "2022", config.Port)
+
+	config, err = FromSSHConfig("db", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "app.example.com", config.Server)
+	assert.Equal(t, "db", config.User)
+
+	// include loop
+	file = writeSSHConfig(t, dir, "loop", "Include loop")
+	_, err = FromSSHConfig("db", file)
+	assert.Error(t, err)
+}
+
+func TestFromSSHConfigProxyJump(t *testing.T) {
+	dir := t.TempDir()
+	file := writeSSHConfig(t, dir, "config",
+		"Host target",
+		"  ProxyJump bastion,admin@inner:2200",
+		"  ProxyCommand ssh -W %h:%p ignored",
+		"Host legacy",
+		"  ProxyCommand ssh -q -l jump -p 2022 -W %h:%p bastion",
+		"Host netcat",
+		"  ProxyCommand nc -X connect -x proxy:3128 %h %p",
+		"Host nested",
+		"  ProxyJump inner",
+		"Host inner",
+		"  HostName 10.0.0.2",
+		"  ProxyJump bastion",
+		"Host bastion",
+		"  HostName bastion.example.com",
+		"  User jump",
+		"  ConnectTimeout 3",
+		"Host loop",
+		"  ProxyJump loop",
+	)
+
+	config, err := FromSSHConfig("target", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "bastion.example.com", config.Proxy.Server)
+	assert.Equal(t, "jump", config.Proxy.User)
+	assert.Equal(t, "22", config.Proxy.Port)
+	assert.Equal(t, 3*time.Second, config.Proxy.Timeout)
+	// inner is itself reached through bastion
+	if assert.Len(t, config.ProxyJump, 2) {
+		assert.Equal(t, "bastion.example.com", config.ProxyJump[0].Server)
+		assert.Equal(t, "10.0.0.2", config.ProxyJump[1].Server)
+		assert.Equal(t, "admin", config.ProxyJump[1].User)
+		assert.Equal(t, "2200", config.ProxyJump[1].Port)
+	}
+
+	config, err = FromSSHConfig("nested", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "bastion.example.com", config.Proxy.Server)
+	if assert.Len(t, config.ProxyJump, 1) {
+		assert.Equal(t, "10.0.0.2", config.ProxyJump[0].Server)
+	}
+
+	config, err = FromSSHConfig("legacy", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "bastion.example.com", config.Proxy.Server)
+	assert.Equal(t, "jump", config.Proxy.User)
+	assert.Equal(t, "2022", config.Proxy.Port)
+	assert.Nil(t, config.ProxyJump)
+
+	_, err = FromSSHConfig("netcat", file)
+	assert.ErrorIs(t, err, ErrUnsupportedProxyCommand)
+
+	_, err = FromSSHConfig("loop", file)
+	assert.Error(t, err)
+}
+
+func TestAlgorithmList(t *testing.T) {
+	defaults := []string{"a-128", "a-256", "b-128"}
+
+	list, err := algorithmList("c,a-128", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a-128"}, list)
+
+	list, err = algorithmList("+c", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-128", "a-256", "b-128", "c"}, list)
+
+	list, err = algorithmList("-*-128", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-256"}, list)
+
+	list, err = algorithmList("^b-128", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b-128", "a-128", "a-256"}, list)
+}
+
+func TestRunCommandFromSSHConfig(t *testing.T) {
+	file := writeSSHConfig(t, t.TempDir(), "config",
+		"Host easyssh-test",
+		"  HostName localhost",
+		"  User drone-scp",
+		"  IdentityFile ./tests/.ssh/id_rsa",
+	)
+
+	config, err := FromSSHConfig("easyssh-test", file)
+	if err != nil {
+		t.Fatalf("FromSSHConfig: %v", err)
+	}
+
+	outStr, _, _, err := config.Run("whoami")
+	assert.Equal(t, "drone-scp\n", outStr)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,272 @@
+package easyssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const defaultSudoUser = "root"
+
+// ErrSudoPassword is returned when sudo asks for a password and none is
+// configured, or asks again because the password was rejected.
+var ErrSudoPassword = errors.New("easyssh: sudo password required or incorrect")
+
+type (
+	// SudoOptions runs the commands with sudo, see MakeConfig.Sudo.
+	SudoOptions struct {
+		// User is the user the commands run as, root when empty.
+		User string
+		// Password answers the password prompt of sudo. It is not needed
+		// when sudo does not ask for one.
+		Password string
+		// PasswordSource, when set, is asked for the password instead of
+		// Password, once per command.
+		PasswordSource SecretSource
+	}
+
+	// sudoSession answers the password prompt of a command started with
+	// sudo and holds its input back until sudo has authenticated.
+	sudoSession struct {
+		// prompt is the prompt of sudo and ready is printed by the command
+		// once sudo has authenticated, both are removed from the output.
+		prompt   string
+		ready    string
+		password string
+		// input is the input of the command, copied to stdin once ready.
+		input io.Reader
+		stdin *io.PipeWriter
+
+		mu       sync.Mutex
+		answered bool
+		// trimNewline is set after a prompt, for the newline sudo prints
+		// once it has read from a terminal.
+		trimNewline bool
+		done        bool
+		// failed is set once the prompt is left unanswered, the prompts
+		// that follow are still removed.
+		failed  bool
+		err     error
+		outputs []*sudoOutput
+		// sent is closed once what was sent to stdin so far is written.
+		sent chan struct{}
+	}
+
+	// sudoOutput removes the prompt and the ready marker from an output of
+	// the command.
+	sudoOutput struct {
+		sudo *sudoSession
+		w    io.Writer
+		// terminal is set for the standard output, which has the prompt
+		// only when the session has a pseudo-terminal.
+		terminal bool
+		pending  []byte
+	}
+)
+
+// newSudoSession returns the sudo session of a command with input as its
+// standard input, and the reader to give the session as standard input.
+func newSudoSession(opts *SudoOptions, input io.Reader) (*sudoSession, io.Reader, error) {
+	password := opts.Password
+	if opts.PasswordSource != nil {
+		var err error
+		if password, err = opts.PasswordSource.Secret(); err != nil {
+			return nil, nil, fmt.Errorf("easyssh: sudo password: %w", err)
+		}
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, nil, err
+	}
+	id := hex.EncodeToString(token)
+
+	r, w := io.Pipe()
+	return &sudoSession{
+		prompt:   "[easyssh-sudo-" + id + "] password: ",
+		ready:    "[easyssh-sudo-" + id + "] ready",
+		password: password,
+		input:    input,
+		stdin:    w,
+	}, r, nil
+}
+
+// command returns command wrapped to run with sudo as user, printing the
+// ready marker once sudo has authenticated.
+func (s *sudoSession) command(command, user string) string {
+	if user == "" {
+		user = defaultSudoUser
+	}
+	script := "printf '%s' " + shellQuote(s.ready) + " >&2\n" + command
+	return "sudo -S -p " + shellQuote(s.prompt) + " -u " + Quote(user) + " -- sh -c " + shellQuote(script)
+}
+
+// output returns w, the standard output or error, with the prompt and the
+// ready marker removed.
+func (s *sudoSession) output(w io.Writer, stdout bool) io.Writer {
+	o := &sudoOutput{sudo: s, w: w, terminal: stdout}
+	s.mu.Lock()
+	s.outputs = append(s.outputs, o)
+	s.mu.Unlock()
+	return o
+}
+
+// handle answers a prompt, read from a terminal or not, or, on the ready
+// marker, starts copying the input of the command.
+func (s *sudoSession) handle(token string, terminal bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+
+	if token == s.ready {
+		s.done = true
+		s.send(func() {
+			var err error
+			if s.input != nil {
+				_, err = io.Copy(s.stdin, s.input)
+			}
+			_ = s.stdin.CloseWithError(err)
+		})
+		return
+	}
+
+	if s.failed {
+		return
+	}
+	if s.answered || s.password == "" {
+		// sudo fails at the end of its input, which a terminal only
+		// reports on an EOF character
+		s.failed = true
+		s.trimNewline = true
+		s.err = ErrSudoPassword
+		s.send(func() {
+			if terminal {
+				_, _ = io.WriteString(s.stdin, "\x04")
+			}
+			_ = s.stdin.Close()
+		})
+		return
+	}
+	s.answered = true
+	s.trimNewline = true
+	s.send(func() { _, _ = io.WriteString(s.stdin, s.password+"\n") })
+}
+
+// send runs write in the background once the previous writes to stdin are
+// done. The writes complete once the input is copied to the session, they
+// must not hold up the output.
+func (s *sudoSession) send(write func()) {
+	prev, next := s.sent, make(chan struct{})
+	s.sent = next
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+		write()
+		close(next)
+	}()
+}
+
+// authenticated reports whether the output needs no more filtering, and
+// takes the newline to trim after a prompt.
+func (s *sudoSession) authenticated() (done, trimNewline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trimNewline, s.trimNewline = s.trimNewline, false
+	return s.done, trimNewline
+}
+
+// finish flushes the outputs and closes the input once the command has
+// exited, and returns ErrSudoPassword when sudo could not authenticate. The
+// copy of the input to the command, if any, ends with the Read of the input
+// in progress, whose data is dropped, rather than waiting for its end.
+func (s *sudoSession) finish() error {
+	s.mu.Lock()
+	outputs := s.outputs
+	s.done = true
+	err := s.err
+	s.mu.Unlock()
+	_ = s.stdin.Close()
+
+	for _, o := range outputs {
+		_ = o.flush()
+	}
+	return err
+}
+
+func (o *sudoOutput) Write(p []byte) (int, error) {
+	data := append(o.pending, p...)
+	o.pending = nil
+	for len(data) > 0 {
+		done, trimNewline := o.sudo.authenticated()
+		if trimNewline {
+			if bytes.HasPrefix(data, []byte("\r\n")) {
+				data = data[2:]
+			} else if data[0] == '\n' {
+				data = data[1:]
+			}
+		}
+		if done {
+			break
+		}
+
+		i, token := o.sudo.index(data)
+		if i < 0 {
+			// keep what may be the start of a token for the next write
+			n := o.sudo.partial(data)
+			o.pending = append(o.pending, data[len(data)-n:]...)
+			data = data[:len(data)-n]
+			break
+		}
+		if _, err := o.w.Write(data[:i]); err != nil {
+			return 0, err
+		}
+		data = data[i+len(token):]
+		o.sudo.handle(token, o.terminal)
+	}
+
+	if len(data) > 0 {
+		if _, err := o.w.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush writes what was kept back as the possible start of a token.
+func (o *sudoOutput) flush() error {
+	if len(o.pending) == 0 {
+		return nil
+	}
+	_, err := o.w.Write(o.pending)
+	o.pending = nil
+	return err
+}
+
+// index returns the position of the first prompt or ready marker in data
+// and the token found, or -1.
+func (s *sudoSession) index(data []byte) (int, string) {
+	i, token := bytes.Index(data, []byte(s.prompt)), s.prompt
+	if j := bytes.Index(data, []byte(s.ready)); j >= 0 && (i < 0 || j < i) {
+		i, token = j, s.ready
+	}
+	return i, token
+}
+
+// partial returns the length of the longest end of data that starts the
+// prompt or the ready marker.
+func (s *sudoSession) partial(data []byte) int {
+	for n := min(len(data), max(len(s.prompt), len(s.ready))-1); n > 0; n-- {
+		end := data[len(data)-n:]
+		if bytes.HasPrefix([]byte(s.prompt), end) || bytes.HasPrefix([]byte(s.ready), end) {
+			return n
+		}
+	}
+	return 0
+}
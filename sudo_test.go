@@ -0,0 +1,218 @@
+package easyssh
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSudoCommandLine(t *testing.T) {
+	s, _, err := newSudoSession(&SudoOptions{}, nil)
+	assert.NoError(t, err)
+
+	cmd := s.command("cat 'a file'", "")
+	assert.True(t, strings.HasPrefix(cmd, "sudo -S -p "+shellQuote(s.prompt)+" -u root -- sh -c "), cmd)
+	assert.Contains(t, cmd, "cat '\\''a file'\\''")
+
+	other, _, err := newSudoSession(&SudoOptions{}, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, s.prompt, other.prompt)
+	assert.Contains(t, other.command("id", "www-data"), " -u www-data -- ")
+}
+
+func TestSudoOutput(t *testing.T) {
+	s, stdin, err := newSudoSession(&SudoOptions{Password: "secret"}, strings.NewReader("input\n"))
+	assert.NoError(t, err)
+	var stdout, stderr bytes.Buffer
+	outW := s.output(&stdout, true)
+	errW := s.output(&stderr, false)
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(stdin)
+		received <- b
+	}()
+
+	// the tokens may be split across writes
+	write := func(w io.Writer, data string) {
+		for i := 0; i < len(data); i += 5 {
+			_, err := w.Write([]byte(data[i:min(i+5, len(data))]))
+			assert.NoError(t, err)
+		}
+	}
+	write(errW, "warning\n"+s.prompt)
+	write(errW, "\n"+s.ready+"error [easyssh\n")
+	write(outW, "[easyssh-sudo-")
+
+	select {
+	case b := <-received:
+		assert.Equal(t, "secret\ninput\n", string(b))
+	case <-time.After(5 * time.Second):
+		t.Fatal("stdin was not closed")
+	}
+	assert.NoError(t, s.finish())
+	assert.Equal(t, "warning\nerror [easyssh\n", stderr.String())
+	assert.Equal(t, "[easyssh-sudo-", stdout.String())
+}
+
+func TestSudoInputNotClosed(t *testing.T) {
+	input, inputW := io.Pipe()
+	defer func() { _ = inputW.Close() }()
+	s, stdin, err := newSudoSession(&SudoOptions{}, input)
+	assert.NoError(t, err)
+	var stderr bytes.Buffer
+	w := s.output(&stderr, false)
+
+	_, err = w.Write([]byte(s.ready))
+	assert.NoError(t, err)
+	_, err = inputW.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := stdin.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", string(buf[:n]))
+
+	// the command exits without reading the rest of its input, which never
+	// ends: the copy stops at its next write
+	assert.NoError(t, s.finish())
+	_, err = inputW.Write([]byte("dropped\n"))
+	assert.NoError(t, err)
+	s.mu.Lock()
+	sent := s.sent
+	s.mu.Unlock()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the input is still being copied")
+	}
+	_, err = stdin.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSudoPasswordRejected(t *testing.T) {
+	for _, password := range []string{"", "wrong"} {
+		s, stdin, err := newSudoSession(&SudoOptions{Password: password}, strings.NewReader("input\n"))
+		assert.NoError(t, err)
+		var stdout bytes.Buffer
+		w := s.output(&stdout, true)
+
+		received := make(chan []byte)
+		go func() {
+			b, _ := io.ReadAll(stdin)
+			received <- b
+		}()
+
+		_, _ = w.Write([]byte(s.prompt + "\r\nSorry, try again.\r\n" + s.prompt))
+		select {
+		case b := <-received:
+			if password == "" {
+				assert.Equal(t, "\x04", string(b))
+			} else {
+				assert.Equal(t, "wrong\n\x04", string(b))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stdin was not closed")
+		}
+		assert.ErrorIs(t, s.finish(), ErrSudoPassword)
+		assert.Equal(t, "Sorry, try again.\r\n", stdout.String())
+	}
+}
+
+func TestSudoOption(t *testing.T) {
+	for _, requestPty := range []bool{false, true} {
+		newline := "\n"
+		if requestPty {
+			newline = "\r\n"
+		}
+		// tests/sudoers asks for the password of drone-scp to run commands as
+		// nobody, and never remembers it
+		sshConf := &MakeConfig{
+			Server:     "localhost",
+			User:       "drone-scp",
+			Port:       "22",
+			KeyPath:    "./tests/.ssh/id_rsa",
+			RequestPty: requestPty,
+			Sudo:       &SudoOptions{User: "nobody", Password: "1234"},
+			Env:        map[string]string{"GREETING": "hello"},
+		}
+
+		outStr, errStr, isTimeout, err := sshConf.RunContext(context.Background(), `whoami; echo "$GREETING"; head -n 1`, WithStdin(strings.NewReader("input\n")))
+		assert.Equal(t, "nobody"+newline+"hello"+newline+"input"+newline, outStr, requestPty)
+		assert.Equal(t, "", errStr, requestPty)
+		assert.True(t, isTimeout, requestPty)
+		assert.NoError(t, err, requestPty)
+
+		sshConf.Sudo = &SudoOptions{User: "nobody", Password: "wrong"}
+		res, err := sshConf.Exec("whoami")
+		assert.ErrorIs(t, err, ErrSudoPassword, requestPty)
+		assert.NotContains(t, res.Stdout, "nobody", requestPty)
+		assert.NotContains(t, res.Stdout+res.Stderr, "easyssh-sudo", requestPty)
+
+		sshConf.Sudo = &SudoOptions{User: "nobody"}
+		_, err = sshConf.Exec("whoami")
+		assert.ErrorIs(t, err, ErrSudoPassword, requestPty)
+	}
+
+	// a single command of a shared Client runs with or without sudo
+	c, err := (&MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		Port:    "22",
+		KeyPath: "./tests/.ssh/id_rsa",
+		Sudo:    &SudoOptions{User: "nobody", Password: "1234"},
+	}).Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+	res, err := c.ExecContext(context.Background(), "whoami", WithSudo(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "drone-scp\n", res.Stdout)
+	res, err = c.ExecContext(context.Background(), "whoami", WithSudo(&SudoOptions{}))
+	assert.NoError(t, err)
+	assert.Equal(t, "root\n", res.Stdout)
+	res, err = c.Exec("whoami")
+	assert.NoError(t, err)
+	assert.Equal(t, "nobody\n", res.Stdout)
+
+	// no password is asked to run commands as root, and the input is left
+	// to the command
+	sshConf := &MakeConfig{
+		Server:     "localhost",
+		User:       "drone-scp",
+		Port:       "22",
+		KeyPath:    "./tests/.ssh/id_rsa",
+		RequestPty: true,
+		Sudo:       &SudoOptions{Password: "unused"},
+	}
+	outStr, errStr, isTimeout, err := sshConf.RunContext(context.Background(), "whoami; head -n 1", WithStdin(strings.NewReader("input\n")))
+	assert.Equal(t, "root\r\ninput\r\n", outStr)
+	assert.Equal(t, "", errStr)
+	assert.True(t, isTimeout)
+	assert.NoError(t, err)
+
+	// the command does not wait for an input that never ends
+	input, inputW := io.Pipe()
+	defer func() { _ = inputW.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err = sshConf.ExecContext(ctx, "whoami", WithStdin(input))
+	assert.NoError(t, err)
+	assert.Equal(t, "root\r\n", res.Stdout)
+	written := make(chan error, 1)
+	go func() {
+		_, err := inputW.Write([]byte("dropped\n"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the input is no longer read")
+	}
+}
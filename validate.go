@@ -0,0 +1,217 @@
+package easyssh
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrMissingValue is reported by Validate for a required field that is
+	// empty.
+	ErrMissingValue = errors.New("value is required")
+	// ErrInvalidPort is reported by Validate for a port that is not a number
+	// between 1 and 65535.
+	ErrInvalidPort = errors.New("invalid port")
+	// ErrInvalidProtocol is reported by Validate for a Protocol other than
+	// tcp, tcp4 and tcp6.
+	ErrInvalidProtocol = errors.New("invalid protocol")
+	// ErrInvalidTimeout is reported by Validate for a negative timeout.
+	ErrInvalidTimeout = errors.New("invalid timeout")
+	// ErrInvalidFingerprint is reported by Validate for a Fingerprint that is
+	// not a SHA256 fingerprint as printed by ssh-keygen -l.
+	ErrInvalidFingerprint = errors.New("invalid fingerprint")
+	// ErrUnsupportedAlgorithm is reported by Validate for a cipher or key
+	// exchange that golang.org/x/crypto/ssh does not implement.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrInvalidKey is reported by Validate for a public key that does not
+	// parse.
+	ErrInvalidKey = errors.New("invalid public key")
+	// ErrInvalidEnv is reported by Validate for a name in MakeConfig.Env that
+	// a shell can not export.
+	ErrInvalidEnv = errors.New("invalid environment variable name")
+	// ErrConflictingOption is reported by Validate for a field set along
+	// with an option it does not work with, which is given as the value.
+	ErrConflictingOption = errors.New("can not be combined with")
+)
+
+// FieldError is a problem with one field of a configuration.
+type FieldError struct {
+	// Field is the path of the field, such as "Port", "Proxy.Port" or
+	// "ProxyJump[1].Ciphers".
+	Field string
+	// Value is the offending value. It is empty for missing values.
+	Value string
+	// Err is one of the Err* values describing the problem.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	if e.Value == "" {
+		return e.Field + ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v %q", e.Field, e.Err, e.Value)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every problem found by Validate.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		problems = append(problems, err.Error())
+	}
+	return "easyssh: invalid config: " + strings.Join(problems, "; ")
+}
+
+// Unwrap returns the *FieldError of every problem.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Validate checks the configuration, including Proxy and ProxyJump, without
+// connecting. It returns a *ValidationError listing every problem, or nil.
+// Cipher and key exchange names are checked against the algorithms
+// golang.org/x/crypto/ssh implements.
+func (ssh_conf *MakeConfig) Validate() error {
+	errs := DefaultConfig{
+		User:              ssh_conf.User,
+		Server:            ssh_conf.Server,
+		Port:              ssh_conf.Port,
+		Protocol:          ssh_conf.Protocol,
+		Timeout:           ssh_conf.Timeout,
+		Ciphers:           ssh_conf.Ciphers,
+		KeyExchanges:      ssh_conf.KeyExchanges,
+		Fingerprint:       ssh_conf.Fingerprint,
+		HostCAKeys:        ssh_conf.HostCAKeys,
+		UseInsecureCipher: ssh_conf.UseInsecureCipher,
+	}.validate("")
+
+	for _, name := range envNames(ssh_conf.Env) {
+		if !validEnvName(name) {
+			errs = append(errs, &FieldError{Field: "Env", Value: name, Err: ErrInvalidEnv})
+		}
+	}
+
+	// the command of a process group has no controlling terminal, and the
+	// login user can not signal the group of a command run by sudo
+	if ssh_conf.ProcessGroup {
+		switch {
+		case ssh_conf.Pty != nil:
+			errs = append(errs, &FieldError{Field: "ProcessGroup", Value: "Pty", Err: ErrConflictingOption})
+		case ssh_conf.RequestPty:
+			errs = append(errs, &FieldError{Field: "ProcessGroup", Value: "RequestPty", Err: ErrConflictingOption})
+		}
+		if ssh_conf.Sudo != nil {
+			errs = append(errs, &FieldError{Field: "ProcessGroup", Value: "Sudo", Err: ErrConflictingOption})
+		}
+	}
+
+	switch proxy := ssh_conf.Proxy; {
+	case proxy.Server != "":
+		errs = append(errs, proxy.validate("Proxy.")...)
+	case proxy.User != "" || proxy.Port != "" || proxy.Key != "" || proxy.KeyPath != "" || proxy.Password != "":
+		// settings for a proxy that would be silently ignored
+		errs = append(errs, &FieldError{Field: "Proxy.Server", Err: ErrMissingValue})
+	}
+
+	for i, hop := range ssh_conf.ProxyJump {
+		errs = append(errs, hop.validate(fmt.Sprintf("ProxyJump[%d].", i))...)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// Validate checks the configuration of a proxy server without connecting, see
+// MakeConfig.Validate.
+func (config DefaultConfig) Validate() error {
+	if errs := config.validate(""); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validate returns the problems of config, with field names prefixed by
+// prefix.
+func (config DefaultConfig) validate(prefix string) []*FieldError {
+	var errs []*FieldError
+	report := func(field, value string, err error) {
+		errs = append(errs, &FieldError{Field: prefix + field, Value: value, Err: err})
+	}
+
+	if config.Server == "" {
+		report("Server", "", ErrMissingValue)
+	}
+	if config.User == "" {
+		report("User", "", ErrMissingValue)
+	}
+
+	if config.Port == "" {
+		report("Port", "", ErrMissingValue)
+	} else if port, err := strconv.Atoi(config.Port); err != nil || port < 1 || port > 65535 {
+		report("Port", config.Port, ErrInvalidPort)
+	}
+
+	switch config.Protocol {
+	case "", PROTOCOL_TCP, PROTOCOL_TCP4, PROTOCOL_TCP6:
+	default:
+		report("Protocol", string(config.Protocol), ErrInvalidProtocol)
+	}
+
+	if config.Timeout < 0 {
+		report("Timeout", config.Timeout.String(), ErrInvalidTimeout)
+	}
+
+	if config.Fingerprint != "" && !validFingerprint(config.Fingerprint) {
+		report("Fingerprint", config.Fingerprint, ErrInvalidFingerprint)
+	}
+
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	for _, cipher := range config.Ciphers {
+		if !slices.Contains(supported.Ciphers, cipher) && !slices.Contains(insecure.Ciphers, cipher) {
+			report("Ciphers", cipher, ErrUnsupportedAlgorithm)
+		}
+	}
+	for _, kex := range config.KeyExchanges {
+		if !slices.Contains(supported.KeyExchanges, kex) && !slices.Contains(insecure.KeyExchanges, kex) {
+			report("KeyExchanges", kex, ErrUnsupportedAlgorithm)
+		}
+	}
+
+	for i, key := range config.HostCAKeys {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			report(fmt.Sprintf("HostCAKeys[%d]", i), "", ErrInvalidKey)
+		}
+	}
+
+	return errs
+}
+
+// validFingerprint reports whether fingerprint has the format of
+// ssh.FingerprintSHA256.
+func validFingerprint(fingerprint string) bool {
+	hash, ok := strings.CutPrefix(fingerprint, "SHA256:")
+	if !ok {
+		return false
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(hash)
+	return err == nil && len(sum) == 32
+}
@@ -0,0 +1,117 @@
+package easyssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &MakeConfig{
+		Server:       "localhost",
+		User:         "drone-scp",
+		Port:         "22",
+		Protocol:     PROTOCOL_TCP4,
+		Ciphers:      []string{"aes256-ctr", "aes128-cbc"},
+		KeyExchanges: []string{"curve25519-sha256", "diffie-hellman-group14-sha1"},
+		Fingerprint:  ssh.FingerprintSHA256(newTestHostKey(t)),
+		Proxy:        DefaultConfig{Server: "bastion", User: "jump", Port: "2222"},
+	}
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, valid.Proxy.Validate())
+
+	config := &MakeConfig{
+		Server:       "localhost",
+		Port:         "0",
+		Protocol:     "udp",
+		Timeout:      -time.Second,
+		Ciphers:      []string{"aes256-ctr", "blowfish-cbc"},
+		KeyExchanges: []string{"curve25519-sha256", "sntrup761x25519-sha512@openssh.com-typo"},
+		Fingerprint:  "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48",
+		HostCAKeys:   []string{"not a key"},
+		Proxy:        DefaultConfig{Server: "bastion", User: "jump"},
+		ProxyJump:    []DefaultConfig{{Server: "inner", User: "jump", Port: "22"}, {User: "jump", Port: "ssh"}},
+	}
+
+	err := config.Validate()
+	var validationErr *ValidationError
+	if !assert.True(t, errors.As(err, &validationErr)) {
+		return
+	}
+
+	fields := map[string]error{}
+	for _, fieldErr := range validationErr.Errors {
+		fields[fieldErr.Field] = fieldErr.Err
+	}
+	assert.Equal(t, map[string]error{
+		"User":                ErrMissingValue,
+		"Port":                ErrInvalidPort,
+		"Protocol":            ErrInvalidProtocol,
+		"Timeout":             ErrInvalidTimeout,
+		"Fingerprint":         ErrInvalidFingerprint,
+		"Ciphers":             ErrUnsupportedAlgorithm,
+		"KeyExchanges":        ErrUnsupportedAlgorithm,
+		"HostCAKeys[0]":       ErrInvalidKey,
+		"Proxy.Port":          ErrMissingValue,
+		"ProxyJump[1].Server": ErrMissingValue,
+		"ProxyJump[1].Port":   ErrInvalidPort,
+	}, fields)
+
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	assert.ErrorIs(t, err, ErrInvalidPort)
+	assert.Contains(t, err.Error(), `Ciphers: unsupported algorithm "blowfish-cbc"`)
+
+	// proxy settings without a proxy server
+	config = &MakeConfig{
+		Server: "localhost",
+		User:   "drone-scp",
+		Port:   "22",
+		Proxy:  DefaultConfig{User: "jump", Port: "22"},
+	}
+	var fieldErr *FieldError
+	if assert.True(t, errors.As(config.Validate(), &fieldErr)) {
+		assert.Equal(t, "Proxy.Server", fieldErr.Field)
+	}
+
+	config.Proxy = DefaultConfig{}
+	config.Env = map[string]string{"LANG": "C", "NOT VALID": "1"}
+	assert.ErrorIs(t, config.Validate(), ErrInvalidEnv)
+
+	// a process group has no terminal and can not be signaled through sudo
+	config.Env = nil
+	config.ProcessGroup = true
+	assert.NoError(t, config.Validate())
+	for _, option := range []string{"RequestPty", "Pty", "Sudo"} {
+		config := *config
+		switch option {
+		case "RequestPty":
+			config.RequestPty = true
+		case "Pty":
+			config.Pty = &PtyOptions{}
+		case "Sudo":
+			config.Sudo = &SudoOptions{}
+		}
+		err := config.Validate()
+		assert.ErrorIs(t, err, ErrConflictingOption, option)
+		assert.ErrorContains(t, err, `ProcessGroup: can not be combined with "`+option+`"`)
+	}
+}
+
+func TestConnectValidates(t *testing.T) {
+	ssh := &MakeConfig{
+		Server:  "localhost",
+		User:    "drone-scp",
+		KeyPath: "./tests/.ssh/id_rsa",
+	}
+
+	session, client, err := ssh.Connect()
+	assert.Nil(t, session)
+	assert.Nil(t, client)
+	assert.ErrorIs(t, err, ErrMissingValue)
+
+	_, _, _, err = ssh.Run("whoami")
+	assert.ErrorIs(t, err, ErrMissingValue)
+}